@@ -14,6 +14,7 @@ import (
 	"unsafe"
 
 	"github.com/pcm720/nhddl-psu/gh"
+	"github.com/pcm720/nhddl-psu/internal/psutime"
 	"github.com/pcm720/psu-go"
 )
 
@@ -21,12 +22,15 @@ import (
 var (
 	Repo      string
 	CORSProxy string
+	APIURL    string // Optional, defaults to GitHub API
+	PublicKey string // Optional minisign or base64-encoded Ed25519 key for release signature verification
 )
 
 // Global variables
 var (
-	ghf *gh.Fetcher
-	b   bytes.Buffer // Reusable file buffer
+	ghf    *gh.Fetcher
+	pubKey *gh.PublicKey
+	b      bytes.Buffer // Reusable file buffer
 )
 
 func main() {
@@ -38,6 +42,15 @@ func main() {
 	ghf = &gh.Fetcher{
 		Repo:      Repo,
 		CORSProxy: CORSProxy,
+		APIURL:    APIURL,
+		Retry:     &gh.RetryPolicy{MaxAttempts: 3},
+	}
+	if PublicKey != "" {
+		var err error
+		if pubKey, err = gh.ParsePublicKey([]byte(PublicKey)); err != nil {
+			displayError(fmt.Sprintf("invalid public key: %s", err))
+			return
+		}
 	}
 	js.Global().Set("getAllTags", getAllTagsWrapper())
 	js.Global().Call("updateTags")
@@ -59,9 +72,30 @@ func getAllTagsWrapper() js.Func {
 				displayError(fmt.Sprintf("Failed to get tags: %s", err))
 				return
 			}
+			// Release metadata is optional, tags without releases are listed as well
+			releases, err := ghf.GetReleases(0)
+			if err != nil {
+				fmt.Printf("failed to get releases: %s\n", err)
+			}
+			byTag := make(map[string]gh.Release, len(releases))
+			for _, r := range releases {
+				if !r.Draft {
+					byTag[r.Tag] = r
+				}
+			}
+
 			arr := make([]any, len(tags))
-			for i, p := range tags {
-				arr[i] = p
+			for i, t := range tags {
+				published := ""
+				r := byTag[t]
+				if !r.Published.IsZero() {
+					published = r.Published.Format(time.DateOnly)
+				}
+				arr[i] = map[string]any{
+					"tag":        t,
+					"published":  published,
+					"prerelease": r.Prerelease,
+				}
 			}
 			js.Global().Call("setTagList", arr)
 		}()
@@ -105,11 +139,24 @@ func generatePSU() js.Func {
 	return js.FuncOf(func(this js.Value, args []js.Value) any {
 		b.Reset()
 
-		if len(args) != 2 {
+		if (len(args) < 2) || (len(args) > 3) {
 			displayError(fmt.Sprintf("Invalid number of arguments"))
 			return nil
 		}
 
+		// Optional progress callback, called with phase, name, bytes read and total size (-1 if unknown)
+		f := *ghf
+		report := func(phase string, name string) {}
+		if (len(args) == 3) && (args[2].Type() == js.TypeFunction) {
+			onProgress := args[2]
+			f.OnProgress = func(p gh.Progress) {
+				onProgress.Invoke(p.Phase, p.Name, float64(p.Read), float64(p.Total))
+			}
+			report = func(phase string, name string) {
+				onProgress.Invoke(phase, name, 0, -1)
+			}
+		}
+
 		tag := args[0].String()
 		if (tag == "") || (tag == "unknown") {
 			return nil
@@ -137,33 +184,50 @@ func generatePSU() js.Func {
 				fmt.Printf("Config: %s\n", c.getYAML())
 				files = append(files, psu.File{
 					Name:     "nhddl.yaml",
-					Created:  time.Now(),
-					Modified: time.Now(),
+					Created:  fileTime(),
+					Modified: fileTime(),
 					Data:     []byte(c.getYAML()),
 				})
 			}
 
 			targetFile := "nhddl.elf"
-			if (tag[0] == 'v') && (tag <= "v1.1.2") {
+			if v, err := gh.ParseVersion(tag); (err == nil) && (v.Compare(gh.Version{Major: 1, Minor: 1, Patch: 2}) <= 0) {
 				// Use standalone version for older releases
 				targetFile = "nhddl-standalone.elf"
 			}
 			fmt.Printf("%s\n", targetFile)
 
-			elfFile, err := ghf.GetFiles(tag, []string{targetFile})
+			elfFile, err := f.GetFiles(tag, []string{targetFile}, gh.FilesOptions{
+				OnVerified: func(asset gh.Asset, sha256 string) {
+					js.Global().Call("setAssetHash", asset.Name, sha256)
+				},
+				PublicKey: pubKey,
+				Rename:    map[string]string{targetFile: "nhddl.elf"},
+			})
 			if err != nil {
 				displayError(fmt.Sprintf("Failed to download ELF: %s\n", err))
 				return
 			}
-			elfFile[0].Name = "nhddl.elf" // Force file name
 			files = append(files, elfFile[0])
 
+			if ts := sourceDateEpoch(); !ts.IsZero() {
+				for i := range files {
+					files[i].Created = ts
+					files[i].Modified = ts
+				}
+			}
+
+			report("building", "nhddl.psu")
 			if err := psu.BuildPSU(&b, "APP_NHDDL", files); err != nil {
 				displayError(fmt.Sprintf("Failed to generate PSU: %s\n", err))
 				return
 			}
 			data := b.Bytes()
+			if !sourceDateEpoch().IsZero() {
+				psutime.Pin(data)
+			}
 			js.Global().Call("saveFile", "nhddl.psu", unsafe.Pointer(&data[0]), len(data))
+			report("done", "nhddl.psu")
 		}(tag, c)
 		return nil
 	})
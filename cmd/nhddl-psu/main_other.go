@@ -0,0 +1,14 @@
+//go:build !(js && wasm)
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// The UI only runs in the browser, this keeps the module buildable on other targets
+func main() {
+	fmt.Fprintln(os.Stderr, "nhddl-psu is a WebAssembly UI, build it with GOOS=js GOARCH=wasm or TinyGo")
+	os.Exit(1)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"embed"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,6 +12,10 @@ import (
 //go:embed nhddl/res/sas/app/*
 var iconResources embed.FS // Embeds icon resources
 
+// Optional Unix time used as creation and modification time of every PSU file for reproducible builds.
+// Must be set at build time
+var SourceDateEpoch string
+
 type NHDDLMode string
 
 const (
@@ -85,9 +90,27 @@ func getEmbeddedFiles() ([]psu.File, error) {
 			Data:     data,
 		}
 		if files[i].Created.IsZero() {
-			files[i].Created = time.Now()
-			files[i].Modified = time.Now()
+			files[i].Created = fileTime()
+			files[i].Modified = fileTime()
 		}
 	}
 	return files, nil
 }
+
+// Returns SourceDateEpoch time or zero time if it's not set or invalid
+func sourceDateEpoch() time.Time {
+	sec, err := strconv.ParseInt(SourceDateEpoch, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0).UTC()
+}
+
+// Returns time for files that don't have a timestamp.
+// Uses SourceDateEpoch if set
+func fileTime() time.Time {
+	if ts := sourceDateEpoch(); !ts.IsZero() {
+		return ts
+	}
+	return time.Now()
+}
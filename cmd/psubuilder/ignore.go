@@ -0,0 +1,145 @@
+//go:build !js
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pcm720/nhddl-psu/internal/pathmatch"
+)
+
+// Name of the file with ignore patterns for the directory it's in
+const ignoreFileName = ".psuignore"
+
+// Ignore pattern in gitignore syntax
+type ignoreRule struct {
+	base    string   // Directory the pattern is relative to
+	elems   []string // Pattern path elements, '**' matches any number of directories
+	negate  bool     // Pattern re-includes matching files
+	dirOnly bool     // Pattern only matches directories
+	pattern string   // Original pattern, for logging
+	source  string   // Where the pattern comes from, for logging
+}
+
+// Ignore patterns in order of precedence, last matching pattern wins
+type ignoreRules []ignoreRule
+
+// Parses ignore pattern relative to the base directory.
+// Returns false if the line is blank or a comment
+func parseIgnoreRule(line string, base string, source string) (ignoreRule, bool, error) {
+	// Trailing spaces are ignored unless escaped
+	trimmed := strings.TrimRight(line, " ")
+	if strings.HasSuffix(trimmed, "\\") && (len(trimmed) < len(line)) {
+		trimmed += " "
+	}
+	line = trimmed
+	pattern := line
+	if (line == "") || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false, nil
+	}
+
+	r := ignoreRule{base: path.Clean(base), pattern: pattern, source: source}
+	if rest, ok := strings.CutPrefix(line, "!"); ok {
+		r.negate, line = true, rest
+	} else if strings.HasPrefix(line, "\\#") || strings.HasPrefix(line, "\\!") {
+		line = line[1:]
+	}
+	if rest, ok := strings.CutSuffix(line, "/"); ok {
+		r.dirOnly, line = true, rest
+	}
+	// Patterns without a slash match files at any depth
+	if !strings.Contains(line, "/") {
+		line = "**/" + line
+	}
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return ignoreRule{}, false, fmt.Errorf("%s: invalid pattern '%s'", source, pattern)
+	}
+
+	r.elems = strings.Split(line, "/")
+	for _, e := range r.elems {
+		if _, err := path.Match(e, ""); err != nil {
+			return ignoreRule{}, false, fmt.Errorf("%s: invalid pattern '%s'", source, pattern)
+		}
+	}
+	return r, true, nil
+}
+
+// Parses exclude patterns relative to the base directory
+func parseExcludes(patterns []string, base string) (ignoreRules, error) {
+	var rules ignoreRules
+	for _, p := range patterns {
+		r, ok, err := parseIgnoreRule(p, base, "exclude")
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules, nil
+}
+
+// Reads ignore file in the directory.
+// Returns nil if the directory doesn't have one
+func readIgnoreFile(dir string) (ignoreRules, error) {
+	name := path.Join(dir, ignoreFileName)
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules ignoreRules
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		r, ok, err := parseIgnoreRule(s.Text(), dir, fmt.Sprintf("%s:%d", name, line))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules, s.Err()
+}
+
+// Returns the pattern that excludes the file or nil if the file is not excluded
+func (rules ignoreRules) match(name string, isDir bool) *ignoreRule {
+	var matched *ignoreRule
+	for i, r := range rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		rel, ok := relPath(r.base, name)
+		if !ok {
+			continue
+		}
+		if pathmatch.Match(r.elems, strings.Split(rel, "/"), false) {
+			matched = &rules[i]
+		}
+	}
+	if (matched == nil) || matched.negate {
+		return nil
+	}
+	return matched
+}
+
+// Returns slash-separated path of the file relative to the directory
+func relPath(dir string, name string) (string, bool) {
+	if dir == "." {
+		return name, !strings.HasPrefix(name, "../")
+	}
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	return strings.CutPrefix(name, dir)
+}
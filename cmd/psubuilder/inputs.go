@@ -0,0 +1,302 @@
+//go:build !js
+
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pcm720/nhddl-psu/gh"
+	"github.com/pcm720/psu-go"
+	"github.com/urfave/cli"
+)
+
+// File input sources
+const (
+	inputLocal   = "local"
+	inputRelease = "release"
+	inputURL     = "url"     // Only available in manifests
+	inputContent = "content" // Generated file, only available in manifests
+)
+
+// Parsed --file argument.
+// Arguments can be qualified with the source: local:<path>, release:<pattern> or release:<owner/repo>@<tag>:<pattern>.
+// Unqualified arguments are treated as release files if --repo is set and as local paths otherwise
+type fileInput struct {
+	source  string   // inputLocal, inputRelease, inputURL or inputContent
+	repo    string   // Release repository, empty for the default repository
+	tag     string   // Release tag, empty for the default tag
+	asset   string   // Release asset name or pattern
+	sha256  string   // Expected release asset or URL file hash
+	path    string   // Local path, release archive file pattern or URL
+	name    string   // PSU file name, empty to keep the original name. Required for URL and generated files
+	content []byte   // Generated file contents
+	exclude []string // Ignore patterns for local directories
+}
+
+// Parses --file argument
+func parseFileInput(arg string, defaultSource string) (fileInput, error) {
+	in := fileInput{source: defaultSource}
+	if rest, ok := strings.CutPrefix(arg, inputLocal+":"); ok {
+		in.source, arg = inputLocal, rest
+	} else if rest, ok := strings.CutPrefix(arg, inputRelease+":"); ok {
+		in.source, arg = inputRelease, rest
+		if repo, tag, pattern, ok := cutReleaseRef(arg); ok {
+			in.repo, in.tag, arg = repo, tag, pattern
+		}
+	}
+
+	in.path, in.name = splitFileMapping(arg)
+	if in.path == "" {
+		return in, fmt.Errorf("empty path in '%s'", arg)
+	}
+	return in, nil
+}
+
+// Splits <owner/repo>@<tag>:<pattern> into the repository, tag and file pattern.
+// Returns false if the part before the first ':' isn't a repository and tag, so file patterns containing '@'
+// (e.g. icons/icon@2x.png) are not mistaken for release references
+func cutReleaseRef(arg string) (string, string, string, bool) {
+	ref, pattern, ok := strings.Cut(arg, ":")
+	if !ok {
+		return "", "", "", false
+	}
+	repo, tag, ok := strings.Cut(ref, "@")
+	if !ok || (tag == "") || !isRepoName(repo) {
+		return "", "", "", false
+	}
+	return repo, tag, pattern, true
+}
+
+// Reports whether name is a repository path (owner/repo or group/subgroup/repo)
+func isRepoName(name string) bool {
+	elems := strings.Split(name, "/")
+	if len(elems) < 2 {
+		return false
+	}
+	for _, elem := range elems {
+		if (elem == "") || (elem == ".") || (elem == "..") {
+			return false
+		}
+		for _, c := range elem {
+			if !((c >= 'a') && (c <= 'z')) && !((c >= 'A') && (c <= 'Z')) && !((c >= '0') && (c <= '9')) && !strings.ContainsRune("._-", c) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Returns key identifying the release asset the file comes from
+func (in fileInput) releaseKey() string {
+	return in.repo + "@" + in.tag + "#" + in.asset + "#" + in.sha256
+}
+
+// Release settings for files that don't set their own repository.
+// Asset and checksum only apply to the default repository, public key applies to every release
+type releaseDefaults struct {
+	repo   string
+	tag    string
+	asset  string
+	sha256 string
+	key    *gh.PublicKey
+}
+
+// Parses --file arguments and collects files from them
+func getFiles(ctx *cli.Context) (*fileSet, error) {
+	defaultSource := inputLocal
+	if ctx.String("repo") != "" {
+		defaultSource = inputRelease
+	}
+
+	if err := checkCollisionPolicy(ctx.String("flatten")); err != nil {
+		return nil, err
+	}
+
+	var inputs []fileInput
+	for _, arg := range ctx.StringSlice("file") {
+		in, err := parseFileInput(arg, defaultSource)
+		if err != nil {
+			return nil, err
+		}
+		if in.source == inputLocal {
+			if in.name == "" {
+				in.name = stripPrefixName(in.path, ctx.String("strip-prefix"))
+			}
+			in.exclude = ctx.StringSlice("exclude")
+		}
+		inputs = append(inputs, in)
+	}
+
+	key, err := getPublicKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return collectFiles(ctx, inputs, releaseDefaults{
+		repo:   ctx.String("repo"),
+		tag:    ctx.String("tag"),
+		asset:  ctx.String("asset"),
+		sha256: ctx.String("sha256"),
+		key:    key,
+	}, ctx.String("flatten"))
+}
+
+// Returns PSU file name for the local path relative to the prefix ('.' if the path is the prefix itself).
+// Returns empty string if the prefix is empty or the path is not under the prefix
+func stripPrefixName(p string, prefix string) string {
+	if prefix == "" {
+		return ""
+	}
+	rel, err := filepath.Rel(prefix, p)
+	if (err != nil) || (rel == "..") || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// Collects files from all inputs in input order.
+// Files from the same release asset are downloaded with a single request.
+// Files with duplicate names are handled according to the collision policy
+func collectFiles(ctx *cli.Context, inputs []fileInput, defaults releaseDefaults, policy string) (*fileSet, error) {
+	releases := map[string][]fileInput{}
+	for i, in := range inputs {
+		if in.source != inputRelease {
+			continue
+		}
+		if in.repo == "" {
+			if defaults.repo == "" {
+				return nil, fmt.Errorf("release file %s requires --repo or owner/repo@tag qualifier", in.path)
+			}
+			in.asset = cmp.Or(in.asset, defaults.asset)
+			in.sha256 = cmp.Or(in.sha256, defaults.sha256)
+		}
+		in.tag = cmp.Or(in.tag, defaults.tag)
+		inputs[i] = in
+		releases[in.releaseKey()] = append(releases[in.releaseKey()], in)
+	}
+
+	set := &fileSet{policy: policy}
+	for _, in := range inputs {
+		switch in.source {
+		case inputLocal:
+			rules, err := parseExcludes(in.exclude, in.path)
+			if err != nil {
+				return nil, err
+			}
+			logf("processing %s\n", in.path)
+			if err := processFile(in.path, in.name, rules, set); err != nil {
+				return nil, err
+			}
+		case inputURL:
+			if err := getURLFile(ctx, in, set); err != nil {
+				return nil, err
+			}
+		case inputContent:
+			logf("generating %s\n", in.name)
+			if err := set.add(psu.File{
+				Name:     in.name,
+				Created:  time.Now(),
+				Modified: time.Now(),
+				Data:     in.content,
+			}, "generated "+in.name); err != nil {
+				return nil, err
+			}
+		case inputRelease:
+			group, ok := releases[in.releaseKey()]
+			if !ok {
+				// Already processed
+				continue
+			}
+			delete(releases, in.releaseKey())
+			if err := getReleaseFiles(ctx, group, defaults, set); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return set, nil
+}
+
+// Downloads files from the release asset and adds them to the set
+func getReleaseFiles(ctx *cli.Context, inputs []fileInput, defaults releaseDefaults, set *fileSet) error {
+	repo := cmp.Or(inputs[0].repo, defaults.repo)
+	opts := gh.FilesOptions{
+		Asset:     inputs[0].asset,
+		SHA256:    inputs[0].sha256,
+		PublicKey: defaults.key,
+		Rename:    map[string]string{},
+	}
+
+	var targetFiles []string
+	for _, in := range inputs {
+		targetFiles = append(targetFiles, in.path)
+		if in.name != "" {
+			opts.Rename[in.path] = in.name
+		}
+	}
+
+	src, err := newSource(ctx, repo)
+	if err != nil {
+		return err
+	}
+	tag := inputs[0].tag
+	opts.OnResolved = func(resolved string, asset gh.Asset) {
+		tag = resolved
+		set.releases = append(set.releases, planRelease{
+			Repo:     repo,
+			Tag:      inputs[0].tag,
+			Resolved: resolved,
+			Asset:    asset.Name,
+			Size:     asset.Size,
+		})
+	}
+	files, err := gh.GetFiles(src, inputs[0].tag, targetFiles, opts)
+	progress.finish()
+	if err != nil {
+		return fmt.Errorf("%s: %w", repo, err)
+	}
+	for _, f := range files {
+		if err := set.add(f, fmt.Sprintf("%s@%s:%s", repo, tag, f.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Downloads file from the URL, verifies its hash if set and adds it to the set
+func getURLFile(ctx *cli.Context, in fileInput, set *fileSet) error {
+	// Fetcher can download any URL as an asset
+	src, err := newSource(ctx, "")
+	if err != nil {
+		return err
+	}
+	r, size, err := gh.DownloadAsset(src, gh.Asset{Name: path.Base(in.path), URL: in.path})
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.NewSectionReader(r, 0, size))
+	progress.finish()
+	if err != nil {
+		return err
+	}
+	if in.sha256 != "" {
+		sum := sha256.Sum256(data)
+		if actual := hex.EncodeToString(sum[:]); actual != strings.ToLower(in.sha256) {
+			return &gh.ChecksumError{Asset: in.path, Source: "provided hash", Expected: in.sha256, Actual: actual}
+		}
+	}
+
+	logln("adding", in.path, "as", in.name)
+	return set.add(psu.File{
+		Name:     in.name,
+		Created:  time.Now(),
+		Modified: time.Now(),
+		Data:     data,
+	}, in.path)
+}
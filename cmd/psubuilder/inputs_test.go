@@ -0,0 +1,215 @@
+//go:build !js
+
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pcm720/nhddl-psu/gh"
+	"github.com/pcm720/nhddl-psu/gh/ghtest"
+)
+
+// Starts the server with v1.0.0 releases of pcm720/nhddl signed with the returned key and unsigned owner/other
+func newSignedServer(t *testing.T) (*ghtest.Server, string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := ghtest.NewServer()
+	t.Cleanup(s.Close)
+
+	signed := ghtest.Asset{Name: "nhddl.zip", Data: ghtest.ZIP(map[string][]byte{"nhddl.elf": []byte("nhddl")})}
+	s.AddRelease("pcm720/nhddl", ghtest.Release{
+		Tag:    "v1.0.0",
+		Assets: []ghtest.Asset{signed, {Name: "nhddl.zip.sig", Data: ed25519.Sign(priv, signed.Data)}},
+	})
+	s.AddRelease("owner/other", ghtest.Release{
+		Tag:    "v1.0.0",
+		Assets: []ghtest.Asset{{Name: "other.zip", Data: ghtest.ZIP(map[string][]byte{"other.elf": []byte("other")})}},
+	})
+	return s, base64.StdEncoding.EncodeToString(pub)
+}
+
+func TestParseFileInput(t *testing.T) {
+	tests := []struct {
+		arg           string
+		defaultSource string
+		expected      fileInput
+	}{
+		{"nhddl.elf", inputRelease, fileInput{source: inputRelease, path: "nhddl.elf"}},
+		{"icons/icon@2x.png", inputLocal, fileInput{source: inputLocal, path: "icons/icon@2x.png"}},
+		{"local:icons/icon@2x.png:icon.png", inputRelease, fileInput{source: inputLocal, path: "icons/icon@2x.png", name: "icon.png"}},
+		{"release:icons/icon@2x.png", inputLocal, fileInput{source: inputRelease, path: "icons/icon@2x.png"}},
+		{"release:icon@2x.png:icon.png", inputLocal, fileInput{source: inputRelease, path: "icon@2x.png", name: "icon.png"}},
+		{"release:owner/repo@v1.0.0:*.elf", inputLocal, fileInput{source: inputRelease, repo: "owner/repo", tag: "v1.0.0", path: "*.elf"}},
+		{
+			"release:owner/repo@v1.0.0:res/icon@2x.png:icon.png", inputLocal,
+			fileInput{source: inputRelease, repo: "owner/repo", tag: "v1.0.0", path: "res/icon@2x.png", name: "icon.png"},
+		},
+		{"release:group/sub/repo@latest:nhddl.elf", inputLocal, fileInput{source: inputRelease, repo: "group/sub/repo", tag: "latest", path: "nhddl.elf"}},
+		{"release:owner/repo@v1.0.0", inputLocal, fileInput{source: inputRelease, path: "owner/repo@v1.0.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			in, err := parseFileInput(tt.arg, tt.defaultSource)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(in, tt.expected) {
+				t.Errorf("got %+v, expected %+v", in, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPublicKeyAppliesToEveryRelease(t *testing.T) {
+	s, key := newSignedServer(t)
+	args := []string{"psubuilder", "psu", "--api-url", s.URL, "--no-cache", "--repo", "pcm720/nhddl", "--tag", "v1.0.0", "--pubkey", key, "--dirname", "APP_NHDDL", "--dry-run", "--file", "nhddl.elf"}
+
+	if err := newApp().Run(args); err != nil {
+		t.Fatal(err)
+	}
+	err := newApp().Run(append(args, "--file", "release:owner/other@v1.0.0:other.elf"))
+	if (err == nil) || !strings.Contains(err.Error(), "signature") {
+		t.Fatalf("expected unsigned release to fail signature verification, got %v", err)
+	}
+}
+
+func TestManifestPublicKeyAppliesToEveryRelease(t *testing.T) {
+	s, key := newSignedServer(t)
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "psu.yaml")
+	build := func(files string) error {
+		data := "repo: pcm720/nhddl\ntag: v1.0.0\npubkey: " + key + "\npsus:\n  - dirname: APP_NHDDL\n    files:\n" + files
+		if err := os.WriteFile(manifest, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return newApp().Run([]string{"psubuilder", "build", "-f", manifest, "--api-url", s.URL, "--no-cache"})
+	}
+
+	if err := build("      - release: nhddl.elf\n"); err != nil {
+		t.Fatal(err)
+	}
+	err := build("      - release: nhddl.elf\n      - release: other.elf\n        repo: owner/other\n")
+	if (err == nil) || !strings.Contains(err.Error(), "signature") {
+		t.Fatalf("expected unsigned release to fail signature verification, got %v", err)
+	}
+}
+
+func TestManifestDefaultTag(t *testing.T) {
+	manifest := filepath.Join(t.TempDir(), "psu.yaml")
+	data := "repo: pcm720/nhddl\npsus:\n  - dirname: APP_NHDDL\n    files:\n      - release: nhddl.elf\n"
+	if err := os.WriteFile(manifest, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := loadManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag := m.releaseDefaults(nil).tag; tag != defaultTag {
+		t.Errorf("manifest tag defaults to %s, expected %s", tag, defaultTag)
+	}
+}
+
+func TestSHA256Mismatch(t *testing.T) {
+	s, _ := newSignedServer(t)
+	args := []string{"psubuilder", "psu", "--api-url", s.URL, "--no-cache", "--repo", "pcm720/nhddl", "--tag", "v1.0.0",
+		"--asset", "nhddl.zip", "--sha256", strings.Repeat("0", 64), "--dirname", "APP_NHDDL", "--dry-run", "--file", "nhddl.elf"}
+	err := newApp().Run(args)
+	var sumErr *gh.ChecksumError
+	if !errors.As(err, &sumErr) || (sumErr.Source != "provided hash") {
+		t.Fatalf("expected provided hash mismatch, got %v", err)
+	}
+}
+
+func TestManifestErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		err      string
+	}{
+		{"empty", "", "manifest is empty"},
+		{"not a mapping", "- psus\n", "line 1: expected mapping"},
+		{"no PSUs", "repo: pcm720/nhddl\n", "line 1: at least one PSU is required"},
+		{"unknown field", "repo: pcm720/nhddl\nrelease: v1.0.0\npsus: []\n", "line 2: unknown field 'release'"},
+		{"invalid hash", "repo: pcm720/nhddl\nsha256: abc\npsus: []\n", "line 2: invalid SHA-256 hash 'abc'"},
+		{"both keys", "pubkey: key\npubkey_file: key.pub\npsus: []\n", "line 2: pubkey and pubkey_file are mutually exclusive"},
+		{"missing dirname", "psus:\n  - files:\n      - local: nhddl.elf\n", "line 2: dirname is required"},
+		{"no files", "psus:\n  - dirname: APP_NHDDL\n    files: []\n", "line 3: at least one file is required"},
+		{
+			"duplicate output",
+			"psus:\n  - dirname: APP_NHDDL\n    files:\n      - local: nhddl.elf\n  - dirname: APP_NHDDL\n    files:\n      - local: nhddl.elf\n",
+			"line 5: output APP_NHDDL.psu is used by another PSU",
+		},
+		{
+			"unknown collision policy",
+			"psus:\n  - dirname: APP_NHDDL\n    flatten: merge\n    files:\n      - local: nhddl.elf\n",
+			"line 3: unknown collision policy 'merge'",
+		},
+		{
+			"unknown file field",
+			"psus:\n  - dirname: APP_NHDDL\n    files:\n      - local: nhddl.elf\n        rename: a.elf\n",
+			"line 5: unknown field 'rename'",
+		},
+		{
+			"several sources",
+			"psus:\n  - dirname: APP_NHDDL\n    files:\n      - local: nhddl.elf\n      - local: nhddl.elf\n        release: nhddl.elf\n",
+			"line 5: exactly one of local, release, url and content must be set",
+		},
+		{
+			"strip_prefix for release",
+			"repo: pcm720/nhddl\npsus:\n  - dirname: APP_NHDDL\n    files:\n      - release: nhddl.elf\n        strip_prefix: res\n",
+			"line 6: strip_prefix can only be set for local files",
+		},
+		{
+			"invalid exclude",
+			"psus:\n  - dirname: APP_NHDDL\n    files:\n      - local: res\n        exclude: ['[']\n",
+			"line 5: exclude: invalid pattern '['",
+		},
+		{
+			"release without repo",
+			"psus:\n  - dirname: APP_NHDDL\n    files:\n      - release: nhddl.elf\n",
+			"line 4: release file requires repo",
+		},
+		{
+			"repo for local file",
+			"psus:\n  - dirname: APP_NHDDL\n    files:\n      - local: nhddl.elf\n        repo: pcm720/nhddl\n",
+			"line 4: repo, tag and asset can only be set for release files",
+		},
+		{
+			"invalid URL",
+			"psus:\n  - dirname: APP_NHDDL\n    files:\n      - local: nhddl.elf\n      - url: ftp://example.com/a.elf\n",
+			"line 5: invalid URL 'ftp://example.com/a.elf'",
+		},
+		{
+			"content without name",
+			"psus:\n  - dirname: APP_NHDDL\n    files:\n      - content: title=NHDDL\n",
+			"line 4: generated file requires name",
+		},
+		{
+			"invalid name",
+			"psus:\n  - dirname: APP_NHDDL\n    files:\n      - local: nhddl.elf\n        name: res/nhddl.elf\n",
+			"line 5: invalid PSU file name 'res/nhddl.elf'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest := filepath.Join(t.TempDir(), "psu.yaml")
+			if err := os.WriteFile(manifest, []byte(tt.manifest), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadManifest(manifest)
+			if (err == nil) || (err.Error() != manifest+": "+tt.err) {
+				t.Errorf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -1,27 +1,118 @@
 //go:build !js
 
-// Small PSU builder utility that can build PSU from local files or from remote GitHub repository
+// Small PSU builder utility that can build PSU from local files or from remote GitHub, Gitea or GitLab repository
 package main
 
 import (
+	"bytes"
 	_ "embed"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
+	"net/url"
 	"os"
 	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/pcm720/nhddl-psu/gh"
+	"github.com/pcm720/nhddl-psu/internal/psutime"
 	"github.com/pcm720/psu-go"
 	"github.com/urfave/cli"
 )
 
 var Version = ""
 
+// Release tag used if neither the --tag flag nor the manifest set it
+const defaultTag = "nightly"
+
+// Writer for progress messages
+var logOutput io.Writer = os.Stdout
+
+// Sets the writer for psubuilder and gh progress messages
+func setLogOutput(w io.Writer) {
+	logOutput = w
+	gh.SetLogOutput(w)
+}
+
+// Keeps progress messages out of JSON output by writing them to the error writer if --json is set.
+// Returns function that restores the log output
+func redirectJSONLogs(ctx *cli.Context) func() {
+	if !ctx.Bool("json") {
+		return func() {}
+	}
+	w := ctx.App.ErrWriter
+	if w == nil {
+		w = cli.ErrWriter
+	}
+	setLogOutput(w)
+	return func() { setLogOutput(os.Stdout) }
+}
+
+func logln(a ...any) {
+	fmt.Fprintln(logOutput, a...)
+}
+
+func logf(format string, a ...any) {
+	fmt.Fprintf(logOutput, format, a...)
+}
+
+var (
+	tokenFlag = cli.StringFlag{
+		Name:   "token",
+		Usage:  "API token. Raises the GitHub API rate limit. If not set, GITHUB_TOKEN is used for the public GitHub API",
+		EnvVar: "PSUBUILDER_TOKEN",
+	}
+	apiURLFlag = cli.StringFlag{
+		Name:   "api-url",
+		Usage:  "API base URL for GitHub Enterprise, Gitea, Forgejo or self-hosted GitLab instances (e.g. https://codeberg.org/api/v1). Defaults to the public provider API",
+		EnvVar: "PSUBUILDER_API_URL",
+	}
+	providerFlag = cli.StringFlag{
+		Name:   "provider",
+		Usage:  "Release provider: github (also used for Gitea and Forgejo) or gitlab",
+		EnvVar: "PSUBUILDER_PROVIDER",
+		Value:  "github",
+	}
+	cacheDirFlag = cli.StringFlag{
+		Name:   "cache-dir",
+		Usage:  "Download cache directory. Defaults to psubuilder directory in the user cache directory",
+		EnvVar: "PSUBUILDER_CACHE_DIR",
+	}
+	noCacheFlag = cli.BoolFlag{
+		Name:   "no-cache",
+		Usage:  "Disable download cache",
+		EnvVar: "PSUBUILDER_NO_CACHE",
+	}
+	retriesFlag = cli.IntFlag{
+		Name:   "retries",
+		Usage:  "Number of times failed requests are retried with exponential backoff. Interrupted downloads are resumed",
+		EnvVar: "PSUBUILDER_RETRIES",
+		Value:  3,
+	}
+	timestampFlag = cli.StringFlag{
+		Name:   "timestamp",
+		Usage:  "Sets creation and modification time of every PSU file for reproducible builds. Accepts Unix time or RFC 3339 time (e.g. 2025-01-01T00:00:00Z)",
+		EnvVar: "SOURCE_DATE_EPOCH",
+	}
+)
+
 func main() {
-	app := &cli.App{
+	if err := newApp().Run(os.Args); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// Returns psubuilder command line app
+func newApp() *cli.App {
+	return &cli.App{
 		Name:        "psubuilder",
-		Description: "Builds PSU from local files or GitHub releases",
+		Description: "Builds PSU from local files or GitHub, Gitea or GitLab releases",
 		Version:     Version,
 		Commands: []cli.Command{
 			{
@@ -30,25 +121,82 @@ func main() {
 				Flags: []cli.Flag{
 					cli.StringFlag{
 						Name:     "repo",
-						Usage:    "GitHub repository to get releases from",
+						Usage:    "Repository to get releases from",
 						EnvVar:   "TARGET_REPO",
 						Required: true,
 					},
+					cli.IntFlag{
+						Name:  "limit",
+						Usage: "Maximum number of tags to get. If not set, all tags are returned",
+					},
+					tokenFlag,
+					apiURLFlag,
+					providerFlag,
+					cacheDirFlag,
+					noCacheFlag,
+					retriesFlag,
 				},
 				Action: func(ctx *cli.Context) error {
-					ghf := &gh.Fetcher{
-						Repo: ctx.String("repo"),
+					src, err := newSource(ctx, ctx.String("repo"))
+					if err != nil {
+						return err
 					}
 
-					tags, err := ghf.GetAllTags()
+					tags, err := src.GetTags(ctx.Int("limit"))
 					if err != nil {
 						return err
 					}
 
-					fmt.Println("Available tags:")
+					fmt.Fprintln(ctx.App.Writer, "Available tags:")
 					for _, t := range tags {
-						fmt.Println(t)
+						fmt.Fprintln(ctx.App.Writer, t)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "releases",
+				Usage: "Get releases",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:     "repo",
+						Usage:    "Repository to get releases from",
+						EnvVar:   "TARGET_REPO",
+						Required: true,
+					},
+					cli.IntFlag{
+						Name:  "limit",
+						Usage: "Maximum number of releases to get. If not set, all releases are returned",
+					},
+					cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print releases as JSON",
+					},
+					tokenFlag,
+					apiURLFlag,
+					providerFlag,
+					cacheDirFlag,
+					noCacheFlag,
+					retriesFlag,
+				},
+				Action: func(ctx *cli.Context) error {
+					defer redirectJSONLogs(ctx)()
+					src, err := newSource(ctx, ctx.String("repo"))
+					if err != nil {
+						return err
+					}
+
+					releases, err := src.GetReleases(ctx.Int("limit"))
+					if err != nil {
+						return err
 					}
+
+					if ctx.Bool("json") {
+						enc := json.NewEncoder(ctx.App.Writer)
+						enc.SetIndent("", "  ")
+						return enc.Encode(releases)
+					}
+					printReleases(ctx.App.Writer, releases)
 					return nil
 				},
 			},
@@ -64,114 +212,500 @@ func main() {
 					},
 					cli.StringFlag{
 						Name:   "tag",
-						Usage:  "Release tag",
+						Usage:  "Release tag. Accepts 'latest', 'latest-stable' and version constraints (e.g. '>=1.2.0 <2')",
 						EnvVar: "RELEASE_TAG",
-						Value:  "nightly",
+						Value:  defaultTag,
+					},
+					cli.StringFlag{
+						Name:   "asset",
+						Usage:  "Release asset name or glob pattern (e.g. 'nhddl-*.zip'). Can be omitted if release has only one asset besides checksum and signature files",
+						EnvVar: "RELEASE_ASSET",
+					},
+					cli.StringFlag{
+						Name:   "sha256",
+						Usage:  "Expected release asset SHA-256 hash. The asset is also verified against the asset digest and SHA256SUMS or <asset>.sha256 release assets if available",
+						EnvVar: "RELEASE_ASSET_SHA256",
+					},
+					cli.StringFlag{
+						Name:   "pubkey",
+						Usage:  "minisign or base64-encoded Ed25519 public key. If set, assets of every release, including releases set with release:<owner/repo>@<tag>:<pattern>, must be signed with <asset>.minisig or <asset>.sig signature",
+						EnvVar: "RELEASE_PUBKEY",
+					},
+					cli.StringFlag{
+						Name:   "pubkey-file",
+						Usage:  "Path to the minisign or Ed25519 public key file. See --pubkey",
+						EnvVar: "RELEASE_PUBKEY_FILE",
 					},
 					cli.StringSliceFlag{
 						Name:     "file",
-						Usage:    "File or directory to include. Multiple files can be specified by repeating this flag. In env variable, multiple files are separated by comma. Files in ZIP release require full path (e.g. dir1/dir2/file).",
+						Usage:    "File or directory to include. Multiple files can be specified by repeating this flag. In env variable, multiple files are separated by comma. Files in release archives (ZIP, tar, tar.gz, tar.xz, tar.zst or 7z) are selected by path relative to the archive root (e.g. dir1/dir2/file), glob pattern (e.g. '**/*.elf') or directory path to include every file under it. Patterns starting with '!' exclude files. Files can be renamed with src:dest syntax (e.g. nhddl-standalone.elf:nhddl.elf). Files can be qualified with the source to mix local and release files: local:<path>, release:<pattern> or release:<owner/repo>@<tag>:<pattern>. Asset and checksum flags only apply to --repo, public key applies to every release file.",
 						EnvVar:   "TARGET_FILES",
 						Required: true,
 					},
+					cli.StringFlag{
+						Name:  "flatten",
+						Usage: "Collision policy for files with the same PSU file name (e.g. files with the same name in different subdirectories): first (keep the first file), last (keep the last file) or rename (add a number to the file name). Duplicate names are refused by default",
+					},
+					cli.StringFlag{
+						Name:  "strip-prefix",
+						Usage: "Names local files under this path after their path relative to it with '/' replaced by '_' (e.g. with 'res', res/a/icon.sys is added as a_icon.sys). Directories can also be named with dir:dest syntax (e.g. res/a:a)",
+					},
+					cli.StringSliceFlag{
+						Name:  "exclude",
+						Usage: "Pattern in .gitignore syntax for files to skip in local directories (e.g. '*.map' or 'build/tmp/'). Can be repeated. Patterns are also read from .psuignore files in every walked directory",
+					},
+					cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "Resolve release tags and assets and print files that would be included in the PSU with the estimated memory card usage without writing it",
+					},
+					cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print dry-run plan as JSON",
+					},
 					cli.StringFlag{
 						Name:   "repo",
-						Usage:  "GitHub repository to get releases from. If not set, 'files' will be treated as local paths",
+						Usage:  "Repository to get releases from. If set, unqualified files are treated as release files, otherwise they are treated as local paths",
 						EnvVar: "TARGET_REPO",
 					},
+					tokenFlag,
+					apiURLFlag,
+					providerFlag,
+					cacheDirFlag,
+					noCacheFlag,
+					retriesFlag,
+					timestampFlag,
 				},
 				Action: func(ctx *cli.Context) error {
-					var files []psu.File
-					if ctx.String("repo") == "" {
-						lfiles, err := getLocalFiles(ctx.StringSlice("file"))
-						if err != nil {
-							return err
-						}
-						files = append(files, lfiles...)
-					} else {
-						ghf := &gh.Fetcher{
-							Repo: ctx.String("repo"),
-						}
-						zipFiles, err := ghf.GetFiles(ctx.String("tag"), ctx.StringSlice("file"))
-						if err != nil {
-							return err
-						}
-						files = append(files, zipFiles...)
+					ts, err := getTimestamp(ctx)
+					if err != nil {
+						return err
+					}
+					if ctx.Bool("json") && !ctx.Bool("dry-run") {
+						return fmt.Errorf("--json can only be used with --dry-run")
+					}
+
+					defer redirectJSONLogs(ctx)()
+					set, err := getFiles(ctx)
+					if err != nil {
+						return err
 					}
 
 					targetFilename := "out.psu"
 					if ctx.Args().Get(0) != "" {
 						targetFilename = ctx.Args().Get(0)
 					}
-					w, err := os.OpenFile(targetFilename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0664)
+					if ctx.Bool("dry-run") {
+						pinTimestamps(set.files, ts)
+						p := newPlan(targetFilename, ctx.String("dirname"), set)
+						if ctx.Bool("json") {
+							enc := json.NewEncoder(ctx.App.Writer)
+							enc.SetIndent("", "  ")
+							return enc.Encode(p)
+						}
+						p.print(ctx.App.Writer)
+						return nil
+					}
+					return writePSU(targetFilename, ctx.String("dirname"), set.files, ts)
+				},
+			},
+			{
+				Name:  "build",
+				Usage: "Build PSUs described in the manifest",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "file, f",
+						Usage: "Path to the build manifest",
+						Value: "psu.yaml",
+					},
+					tokenFlag,
+					apiURLFlag,
+					providerFlag,
+					cacheDirFlag,
+					noCacheFlag,
+					retriesFlag,
+					timestampFlag,
+				},
+				Action: func(ctx *cli.Context) error {
+					ts, err := getTimestamp(ctx)
+					if err != nil {
+						return err
+					}
+					m, err := loadManifest(ctx.String("file"))
 					if err != nil {
 						return err
 					}
-					defer w.Close()
-					if err := psu.BuildPSU(w, ctx.String("dirname"), files); err != nil {
+					dir := filepath.Dir(ctx.String("file"))
+					key, err := m.publicKey(dir)
+					if err != nil {
 						return err
 					}
-					fmt.Println("PSU built successfully")
+
+					for _, p := range m.PSUs {
+						logln("building", p.DirName)
+						set, err := collectFiles(ctx, p.inputs(dir), m.releaseDefaults(key), p.Flatten)
+						if err != nil {
+							return fmt.Errorf("%s: %w", p.DirName, err)
+						}
+						if err := writePSU(resolvePath(dir, p.Output), p.DirName, set.files, ts); err != nil {
+							return err
+						}
+					}
 					return nil
 				},
 			},
+			{
+				Name:  "cache",
+				Usage: "Manage download cache",
+				Subcommands: []cli.Command{
+					{
+						Name:  "list",
+						Usage: "List cached responses",
+						Flags: []cli.Flag{cacheDirFlag},
+						Action: func(ctx *cli.Context) error {
+							c, err := newCache(ctx)
+							if err != nil {
+								return err
+							}
+							entries, err := c.List()
+							if err != nil {
+								return err
+							}
+
+							w := tabwriter.NewWriter(ctx.App.Writer, 0, 0, 2, ' ', 0)
+							fmt.Fprintln(w, "URL\tSIZE\tUPDATED\tSHA256")
+							for _, e := range entries {
+								fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", e.URL, e.Size, e.Updated.Local().Format(time.DateTime), e.SHA256)
+							}
+							return w.Flush()
+						},
+					},
+					{
+						Name:  "prune",
+						Usage: "Remove entries that haven't been used for a while",
+						Flags: []cli.Flag{
+							cacheDirFlag,
+							cli.DurationFlag{
+								Name:  "older-than",
+								Usage: "Remove entries that haven't been revalidated for this duration",
+								Value: 30 * 24 * time.Hour,
+							},
+						},
+						Action: func(ctx *cli.Context) error {
+							c, err := newCache(ctx)
+							if err != nil {
+								return err
+							}
+							removed, err := c.Prune(ctx.Duration("older-than"))
+							if err != nil {
+								return err
+							}
+							fmt.Fprintf(ctx.App.Writer, "removed %d entries\n", removed)
+							return nil
+						},
+					},
+					{
+						Name:  "clear",
+						Usage: "Remove all cached data",
+						Flags: []cli.Flag{cacheDirFlag},
+						Action: func(ctx *cli.Context) error {
+							c, err := newCache(ctx)
+							if err != nil {
+								return err
+							}
+							return c.Clear()
+						},
+					},
+				},
+			},
 		},
 	}
+}
 
-	if err := app.Run(os.Args); err != nil {
-		fmt.Println(err)
+// Writes PSU to the file.
+// If ts is not zero, it is used as creation and modification time of every file and directory entry
+func writePSU(name string, dirName string, files []psu.File, ts time.Time) error {
+	pinTimestamps(files, ts)
+	b := &bytes.Buffer{}
+	if err := psu.BuildPSU(b, dirName, files); err != nil {
+		return err
+	}
+	if !ts.IsZero() {
+		// Directory entries must not depend on the build time either.
+		// psu-go doesn't accept directory timestamps, so they are copied from the first file entry
+		psutime.Pin(b.Bytes())
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(name, b.Bytes(), 0664); err != nil {
+		return err
+	}
+	logln("PSU built successfully:", name)
+	return nil
+}
+
+// Sets creation and modification time of every file to ts if ts is not zero
+func pinTimestamps(files []psu.File, ts time.Time) {
+	if ts.IsZero() {
+		return
+	}
+	for i := range files {
+		files[i].Created = ts
+		files[i].Modified = ts
+	}
+}
+
+// Parses timestamp set in command flags or SOURCE_DATE_EPOCH.
+// Returns zero time if the timestamp is not set
+func getTimestamp(ctx *cli.Context) (time.Time, error) {
+	v := ctx.String("timestamp")
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0).UTC(), nil
+	}
+	ts, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp '%s': expected Unix time or RFC 3339 time", v)
+	}
+	return ts.UTC(), nil
+}
+
+// Creates release source for the repository and the provider set in command flags
+func newSource(ctx *cli.Context, repo string) (gh.Source, error) {
+	f := gh.Fetcher{
+		Repo:   repo,
+		Token:  apiToken(ctx.String("token"), ctx.String("provider"), ctx.String("api-url")),
+		APIURL: ctx.String("api-url"),
+		Retry:  &gh.RetryPolicy{MaxAttempts: ctx.Int("retries") + 1},
+	}
+	if progress != nil {
+		f.OnProgress = progress.update
+	}
+	if !ctx.Bool("no-cache") {
+		c, err := newCache(ctx)
+		if err != nil {
+			return nil, err
+		}
+		f.Cache = c
+	}
+
+	switch ctx.String("provider") {
+	case "github":
+		return &f, nil
+	case "gitlab":
+		return &gh.GitLab{Fetcher: f}, nil
+	default:
+		return nil, fmt.Errorf("unknown provider '%s'", ctx.String("provider"))
+	}
+}
+
+// Returns API token for the provider.
+// Falls back on GITHUB_TOKEN only for the public GitHub API, so it's never sent to other hosts
+func apiToken(token string, provider string, apiURL string) string {
+	if (token != "") || (provider != "github") {
+		return token
+	}
+	if apiURL == "" {
+		return os.Getenv("GITHUB_TOKEN")
+	}
+	if u, err := url.Parse(apiURL); (err == nil) && (u.Hostname() == "api.github.com") {
+		return os.Getenv("GITHUB_TOKEN")
+	}
+	return ""
+}
+
+// Parses public key set in command flags.
+// Returns nil if the key is not set
+func getPublicKey(ctx *cli.Context) (*gh.PublicKey, error) {
+	var data []byte
+	switch {
+	case (ctx.String("pubkey") != "") && (ctx.String("pubkey-file") != ""):
+		return nil, fmt.Errorf("--pubkey and --pubkey-file are mutually exclusive")
+	case ctx.String("pubkey") != "":
+		data = []byte(ctx.String("pubkey"))
+	case ctx.String("pubkey-file") != "":
+		var err error
+		if data, err = os.ReadFile(ctx.String("pubkey-file")); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
 	}
+	return gh.ParsePublicKey(data)
 }
 
-// Parses filenames into psu.Files
-// Handles directories recursively
-func getLocalFiles(filenames []string) ([]psu.File, error) {
-	var res []psu.File
-	for _, f := range filenames {
-		fmt.Printf("processing %s\n", f)
-		files, err := processFile(f)
+// Creates download cache in the directory set in command flags
+func newCache(ctx *cli.Context) (*gh.Cache, error) {
+	dir := ctx.String("cache-dir")
+	if dir == "" {
+		userDir, err := os.UserCacheDir()
 		if err != nil {
 			return nil, err
 		}
-		res = append(res, files...)
+		dir = filepath.Join(userDir, "psubuilder")
+	}
+	return &gh.Cache{Dir: dir}, nil
+}
+
+// Prints releases as a table
+func printReleases(out io.Writer, releases []gh.Release) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TAG\tNAME\tPUBLISHED\tFLAGS\tASSETS")
+	for _, r := range releases {
+		var flags []string
+		if r.Draft {
+			flags = append(flags, "draft")
+		}
+		if r.Prerelease {
+			flags = append(flags, "prerelease")
+		}
+		published := "-"
+		if !r.Published.IsZero() {
+			published = r.Published.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", r.Tag, r.Name, published, strings.Join(flags, ","), len(r.Assets))
+	}
+	w.Flush()
+}
+
+// Collision policies for files with the same PSU file name
+const (
+	collisionError  = "error"  // Refuse duplicate names
+	collisionFirst  = "first"  // Keep the first file
+	collisionLast   = "last"   // Keep the last file
+	collisionRename = "rename" // Add a number to the name of the duplicate file
+)
+
+// PSU files with their sources
+type fileSet struct {
+	files    []psu.File
+	sources  map[string]string // PSU file name to file source
+	index    map[string]int    // PSU file name to file index
+	policy   string            // Collision policy, defaults to collisionError
+	releases []planRelease     // Release assets the files come from
+}
+
+// Adds file to the set. Files that have already been added from the same source are skipped.
+// Files with duplicate names are handled according to the collision policy
+func (s *fileSet) add(f psu.File, source string) error {
+	if s.sources == nil {
+		s.sources = map[string]string{}
+		s.index = map[string]int{}
+	}
+	if prev, ok := s.sources[f.Name]; ok {
+		if prev == source {
+			return nil
+		}
+		switch s.policy {
+		case collisionFirst:
+			logf("skipping %s: %s is already added from %s\n", source, f.Name, prev)
+			return nil
+		case collisionLast:
+			logf("replacing %s with %s\n", prev, source)
+			s.sources[f.Name] = source
+			s.files[s.index[f.Name]] = f
+			return nil
+		case collisionRename:
+			ext := path.Ext(f.Name)
+			base := strings.TrimSuffix(f.Name, ext)
+			for i := 1; ; i++ {
+				name := fmt.Sprintf("%s_%d%s", base, i, ext)
+				if _, ok := s.sources[name]; !ok {
+					logf("adding %s as %s: %s is already added from %s\n", source, name, f.Name, prev)
+					f.Name = name
+					break
+				}
+			}
+		default:
+			return &gh.CollisionError{Name: f.Name, First: prev, Second: source}
+		}
+	}
+	s.sources[f.Name] = source
+	s.index[f.Name] = len(s.files)
+	s.files = append(s.files, f)
+	return nil
+}
+
+// Validates collision policy
+func checkCollisionPolicy(policy string) error {
+	switch policy {
+	case "", collisionError, collisionFirst, collisionLast, collisionRename:
+		return nil
+	}
+	return fmt.Errorf("unknown collision policy '%s'", policy)
+}
+
+// Splits file argument into the source path and PSU file name (src:dest).
+// Returns empty PSU file name if the argument doesn't have one
+func splitFileMapping(arg string) (string, string) {
+	idx := strings.LastIndex(arg, ":")
+	if idx < 0 {
+		return arg, ""
+	}
+	// Don't treat Windows drive letters (e.g. C:\dir) as mappings
+	dest := arg[idx+1:]
+	if (dest == "") || strings.ContainsAny(dest, "/\\") {
+		return arg, ""
 	}
-	return res, nil
+	return arg[:idx], dest
 }
 
-// Reads files and directories recursively
-func processFile(name string) ([]psu.File, error) {
-	var res []psu.File
+// Reads files and directories recursively and adds them to the set.
+// If psuName is not empty, the file is added under this name.
+// For directories, psuName is the name prefix for files in the directory ('.' for no prefix).
+// Files in subdirectories of a named directory are named after their path with '/' replaced by '_'.
+// Directory entries matching ignore rules or patterns from .psuignore files in walked directories are skipped
+func processFile(name string, psuName string, rules ignoreRules, set *fileSet) error {
 	lf, err := os.Open(name)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer lf.Close()
 
 	info, err := lf.Stat()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	if info.IsDir() {
 		entries, err := lf.ReadDir(0)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		dirRules, err := readIgnoreFile(name)
+		if err != nil {
+			return err
+		}
+		rules = append(slices.Clip(rules), dirRules...)
+
+		// Keep file order stable
+		slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
 		for _, e := range entries {
+			if e.Name() == ignoreFileName {
+				continue
+			}
 			fullPath := path.Join(name, e.Name())
-			fmt.Printf("processing %s\n", fullPath)
-			files, err := processFile(fullPath)
-			if err != nil {
-				return nil, err
+			if r := rules.match(fullPath, e.IsDir()); r != nil {
+				logf("excluding %s (%s: %s)\n", fullPath, r.source, r.pattern)
+				continue
+			}
+			logf("processing %s\n", fullPath)
+			childName := ""
+			if psuName != "" {
+				childName = path.Join(psuName, e.Name())
+			}
+			if err := processFile(fullPath, childName, rules, set); err != nil {
+				return err
 			}
-			res = append(res, files...)
 		}
-		return res, nil
+		return nil
 	}
 
 	data, err := io.ReadAll(lf)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	mTime := info.ModTime()
@@ -179,12 +713,14 @@ func processFile(name string) ([]psu.File, error) {
 		mTime = time.Now()
 	}
 
-	res = append(res, psu.File{
-		Name:     path.Base(lf.Name()),
+	if (psuName == "") || (psuName == ".") {
+		psuName = path.Base(lf.Name())
+	}
+	psuName = strings.ReplaceAll(psuName, "/", "_")
+	return set.add(psu.File{
+		Name:     psuName,
 		Created:  mTime,
 		Modified: mTime,
 		Data:     data,
-	})
-
-	return res, nil
+	}, name)
 }
@@ -0,0 +1,376 @@
+//go:build !js
+
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pcm720/nhddl-psu/gh"
+	"github.com/pcm720/nhddl-psu/gh/ghtest"
+)
+
+func TestAPIToken(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "gh-token")
+	tests := []struct {
+		token    string
+		provider string
+		apiURL   string
+		expected string
+	}{
+		{"", "github", "", "gh-token"},
+		{"", "github", "https://api.github.com", "gh-token"},
+		{"", "github", "https://api.github.com/", "gh-token"},
+		{"explicit", "github", "", "explicit"},
+		{"explicit", "github", "https://codeberg.org/api/v1", "explicit"},
+		{"", "github", "https://codeberg.org/api/v1", ""},
+		{"", "github", "https://github.example.com/api/v3", ""},
+		{"", "github", "https://api.github.com.example.com", ""},
+		{"", "gitlab", "", ""},
+		{"explicit", "gitlab", "", "explicit"},
+	}
+	for _, tt := range tests {
+		if token := apiToken(tt.token, tt.provider, tt.apiURL); token != tt.expected {
+			t.Errorf("apiToken(%q, %q, %q) = %q, expected %q", tt.token, tt.provider, tt.apiURL, token, tt.expected)
+		}
+	}
+}
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestGoldenPSU(t *testing.T) {
+	// Pins file and directory entry timestamps, so the PSU doesn't depend on the build time
+	t.Setenv("SOURCE_DATE_EPOCH", "1735689600")
+	dir := t.TempDir()
+	args := []string{"psubuilder", "psu", "--dirname", "APP_TEST"}
+	for _, f := range [][2]string{{"title.cfg", "title=Test\n"}, {"boot.elf", strings.Repeat("ELF", 500)}} {
+		path := filepath.Join(dir, f[0])
+		if err := os.WriteFile(path, []byte(f[1]), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		args = append(args, "--file", path)
+	}
+	output := filepath.Join(dir, "golden.psu")
+	if err := newApp().Run(append(args, output)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "golden.psu")
+	if *update {
+		if err := os.WriteFile(golden, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, want) {
+		t.Errorf("PSU doesn't match %s, run 'go test -run TestGoldenPSU -update' if the change is expected", golden)
+	}
+}
+
+func TestReleasesJSON(t *testing.T) {
+	s := ghtest.NewServer()
+	t.Cleanup(s.Close)
+	s.AddRelease("pcm720/nhddl", ghtest.Release{Tag: "v1.0.0"})
+	s.AddRelease("pcm720/nhddl", ghtest.Release{Tag: "v1.1.0", Prerelease: true})
+	cacheDir := t.TempDir()
+
+	// The second run revalidates cached responses, which logs cache messages
+	for i := range 2 {
+		out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+		app := newApp()
+		app.Writer = out
+		app.ErrWriter = errOut
+		if err := app.Run([]string{"psubuilder", "releases", "--api-url", s.URL, "--cache-dir", cacheDir, "--repo", "pcm720/nhddl", "--json"}); err != nil {
+			t.Fatal(err)
+		}
+		if (i == 1) && !strings.Contains(errOut.String(), "using cached response") {
+			t.Errorf("expected cache messages to be written to the error writer, got %q", errOut)
+		}
+		var releases []struct {
+			Tag string `json:"tag"`
+		}
+		if err := json.Unmarshal(out.Bytes(), &releases); err != nil {
+			t.Fatalf("run %d: invalid JSON output: %s\n%s", i+1, err, out)
+		}
+		if (len(releases) != 2) || (releases[0].Tag != "v1.1.0") {
+			t.Errorf("run %d: unexpected releases %+v", i+1, releases)
+		}
+	}
+	if logOutput != os.Stdout {
+		t.Error("log output hasn't been restored")
+	}
+}
+
+func TestReleasesTable(t *testing.T) {
+	s := ghtest.NewServer()
+	t.Cleanup(s.Close)
+	s.AddRelease("pcm720/nhddl", ghtest.Release{Tag: "v1.0.0", Prerelease: true})
+
+	out := &bytes.Buffer{}
+	app := newApp()
+	app.Writer = out
+	if err := app.Run([]string{"psubuilder", "releases", "--api-url", s.URL, "--no-cache", "--repo", "pcm720/nhddl"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "TAG") || !strings.Contains(out.String(), "prerelease") {
+		t.Errorf("unexpected releases table:\n%s", out)
+	}
+}
+
+func TestDryRunJSON(t *testing.T) {
+	s := ghtest.NewServer()
+	t.Cleanup(s.Close)
+	s.AddRelease("pcm720/nhddl", ghtest.Release{
+		Tag:    "v1.0.0",
+		Assets: []ghtest.Asset{{Name: "nhddl.zip", Data: ghtest.ZIP(map[string][]byte{"nhddl.elf": []byte("nhddl")})}},
+	})
+
+	out := &bytes.Buffer{}
+	app := newApp()
+	app.Writer = out
+	args := []string{"psubuilder", "psu", "--api-url", s.URL, "--no-cache", "--repo", "pcm720/nhddl", "--tag", "v1.0.0",
+		"--dirname", "APP_NHDDL", "--dry-run", "--json", "--file", "nhddl.elf"}
+	if err := app.Run(args); err != nil {
+		t.Fatal(err)
+	}
+
+	// Progress messages must not be mixed with the plan
+	var p plan
+	if err := json.Unmarshal(out.Bytes(), &p); err != nil {
+		t.Fatalf("invalid JSON output: %s\n%s", err, out)
+	}
+	if (len(p.Files) != 1) || (p.Files[0].Name != "nhddl.elf") || (len(p.Releases) != 1) || (p.Releases[0].Resolved != "v1.0.0") {
+		t.Errorf("unexpected plan %+v", p)
+	}
+	if logOutput != os.Stdout {
+		t.Error("log output hasn't been restored")
+	}
+}
+
+func TestProgressThrottle(t *testing.T) {
+	var buf bytes.Buffer
+	b := &progressBar{w: &buf}
+	for read := int64(1); read <= 1000; read++ {
+		b.update(gh.Progress{Phase: gh.PhaseDownloading, Name: "nhddl.zip", Read: read, Total: -1})
+	}
+	if redraws := strings.Count(buf.String(), "\r"); redraws != 1 {
+		t.Errorf("unknown size progress was rendered %d times, expected 1", redraws)
+	}
+
+	buf.Reset()
+	b.drawn = time.Now().Add(-progressInterval)
+	b.update(gh.Progress{Phase: gh.PhaseDownloading, Name: "nhddl.zip", Read: 1001, Total: -1})
+	b.update(gh.Progress{Phase: gh.PhaseDownloading, Name: "nhddl.zip", Read: 1001, Total: 1001})
+	if out := buf.String(); !strings.Contains(out, "1001 B\r") || !strings.HasSuffix(out, "100% 1001 B / 1001 B\n") {
+		t.Errorf("unexpected progress output %q", out)
+	}
+}
+
+// Creates files in a temporary directory and returns the directory path
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// Returns PSU file names mapped to file contents
+func setContents(set *fileSet) map[string]string {
+	files := map[string]string{}
+	for _, f := range set.files {
+		files[f.Name] = string(f.Data)
+	}
+	return files
+}
+
+func TestCollisionPolicy(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"res/a/icon.sys":  "a",
+		"res/b/icon.sys":  "b",
+		"res/b/title.cfg": "title",
+	})
+	tests := []struct {
+		policy   string
+		expected map[string]string // nil if the collision must be refused
+	}{
+		{"", nil},
+		{collisionError, nil},
+		{collisionFirst, map[string]string{"icon.sys": "a", "title.cfg": "title"}},
+		{collisionLast, map[string]string{"icon.sys": "b", "title.cfg": "title"}},
+		{collisionRename, map[string]string{"icon.sys": "a", "icon_1.sys": "b", "title.cfg": "title"}},
+	}
+	for _, tt := range tests {
+		t.Run(cmp.Or(tt.policy, "default"), func(t *testing.T) {
+			set := &fileSet{policy: tt.policy}
+			err := processFile(filepath.Join(dir, "res"), "", nil, set)
+			if tt.expected == nil {
+				var collision *gh.CollisionError
+				if !errors.As(err, &collision) || (collision.Name != "icon.sys") {
+					t.Fatalf("expected icon.sys collision, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if files := setContents(set); !maps.Equal(files, tt.expected) {
+				t.Errorf("got %v, expected %v", files, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCollisionSameSource(t *testing.T) {
+	dir := writeTree(t, map[string]string{"nhddl.elf": "nhddl"})
+	set := &fileSet{}
+	for range 2 {
+		if err := processFile(filepath.Join(dir, "nhddl.elf"), "", nil, set); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(set.files) != 1 {
+		t.Errorf("got %d files, expected file from the same source to be added once", len(set.files))
+	}
+}
+
+func TestStripPrefix(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"res/a/icon.sys":  "a",
+		"res/b/icon.sys":  "b",
+		"res/b/title.cfg": "title",
+	})
+	res := filepath.Join(dir, "res")
+	tests := []struct {
+		name     string
+		path     string
+		prefix   string
+		expected map[string]string
+	}{
+		{"directory", res, res, map[string]string{"a_icon.sys": "a", "b_icon.sys": "b", "b_title.cfg": "title"}},
+		{"parent", filepath.Join(res, "b"), res, map[string]string{"b_icon.sys": "b", "b_title.cfg": "title"}},
+		{"file", filepath.Join(res, "a", "icon.sys"), filepath.Join(res, "a"), map[string]string{"icon.sys": "a"}},
+		{"nested file", filepath.Join(res, "a", "icon.sys"), dir, map[string]string{"res_a_icon.sys": "a"}},
+		// Paths outside of the prefix keep their base names
+		{"outside", filepath.Join(res, "b"), filepath.Join(res, "a"), map[string]string{"icon.sys": "b", "title.cfg": "title"}},
+		{"sibling", filepath.Join(res, "b"), filepath.Join(dir, "re"), map[string]string{"icon.sys": "b", "title.cfg": "title"}},
+		{"no prefix", filepath.Join(res, "b"), "", map[string]string{"icon.sys": "b", "title.cfg": "title"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := &fileSet{}
+			if err := processFile(tt.path, stripPrefixName(tt.path, tt.prefix), nil, set); err != nil {
+				t.Fatal(err)
+			}
+			if files := setContents(set); !maps.Equal(files, tt.expected) {
+				t.Errorf("got %v, expected %v", files, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIgnoreFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		excludes []string
+		expected []string
+	}{
+		{
+			"negation",
+			map[string]string{".psuignore": "*.bak\n!keep.bak\n", "a.bak": "", "keep.bak": "", "dir/b.bak": "", "dir/keep.bak": "", "a.elf": ""},
+			nil, []string{"a.elf", "dir_keep.bak", "keep.bak"},
+		},
+		{
+			"negation order",
+			map[string]string{".psuignore": "!keep.bak\n*.bak\n", "a.bak": "", "keep.bak": "", "a.elf": ""},
+			nil, []string{"a.elf"},
+		},
+		{
+			"nested negation",
+			map[string]string{".psuignore": "*.bak\n", "dir/.psuignore": "!b.bak\n", "a.bak": "", "dir/b.bak": "", "dir/c.bak": ""},
+			nil, []string{"dir_b.bak"},
+		},
+		{
+			"anchored",
+			map[string]string{".psuignore": "/top.txt\n", "top.txt": "", "dir/top.txt": ""},
+			nil, []string{"dir_top.txt"},
+		},
+		{
+			"anchored to nested directory",
+			map[string]string{"dir/.psuignore": "/top.txt\n", "top.txt": "", "dir/top.txt": "", "dir/sub/top.txt": ""},
+			nil, []string{"dir_sub_top.txt", "top.txt"},
+		},
+		{
+			"path",
+			map[string]string{".psuignore": "dir/*.txt\n", "a.txt": "", "dir/a.txt": "", "other/dir/a.txt": ""},
+			nil, []string{"a.txt", "other_dir_a.txt"},
+		},
+		{
+			"directory only",
+			map[string]string{".psuignore": "build/\n", "build/a.elf": "", "dir/build/b.elf": "", "a.elf": ""},
+			nil, []string{"a.elf"},
+		},
+		{
+			"directory only keeps files",
+			map[string]string{".psuignore": "build/\n", "build/a.elf": "", "dir/build": "x"},
+			nil, []string{"dir_build"},
+		},
+		{
+			"recursive",
+			map[string]string{".psuignore": "dir/**/*.bak\n", "dir/a.bak": "", "dir/sub/deep/b.bak": "", "c.bak": ""},
+			nil, []string{"c.bak"},
+		},
+		{
+			"comments and escapes",
+			map[string]string{".psuignore": "# comment\n\\#hash\n\\!bang\n\n", "#hash": "", "!bang": "", "comment": ""},
+			nil, []string{"comment"},
+		},
+		{
+			"exclude negated by ignore file",
+			map[string]string{".psuignore": "!keep.bak\n", "a.bak": "", "keep.bak": ""},
+			[]string{"*.bak"}, []string{"keep.bak"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeTree(t, tt.files)
+			rules, err := parseExcludes(tt.excludes, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			set := &fileSet{}
+			if err := processFile(dir, ".", rules, set); err != nil {
+				t.Fatal(err)
+			}
+			names := slices.Sorted(maps.Keys(setContents(set)))
+			if !slices.Equal(names, tt.expected) {
+				t.Errorf("got %v, expected %v", names, tt.expected)
+			}
+		})
+	}
+}
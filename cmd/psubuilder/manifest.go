@@ -0,0 +1,298 @@
+//go:build !js
+
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/pcm720/nhddl-psu/gh"
+	"gopkg.in/yaml.v3"
+)
+
+// Build manifest describing one or more PSUs.
+// Release settings apply to release files that don't set their own repository, public key applies to every release file
+type manifest struct {
+	Repo          string        `yaml:"repo"`
+	Tag           string        `yaml:"tag"`
+	Asset         string        `yaml:"asset"`
+	SHA256        string        `yaml:"sha256"`
+	PublicKey     string        `yaml:"pubkey"`
+	PublicKeyFile string        `yaml:"pubkey_file"` // Relative to the manifest directory
+	PSUs          []manifestPSU `yaml:"psus"`
+
+	node *yaml.Node
+}
+
+type manifestPSU struct {
+	DirName string         `yaml:"dirname"`
+	Output  string         `yaml:"output"`  // Relative to the manifest directory, defaults to <dirname>.psu
+	Flatten string         `yaml:"flatten"` // Collision policy for files with the same PSU file name
+	Files   []manifestFile `yaml:"files"`
+
+	node *yaml.Node
+}
+
+// PSU file source. Exactly one of Local, Release, URL and Content must be set
+type manifestFile struct {
+	Local       string   `yaml:"local"`        // Local file or directory, relative to the manifest directory
+	StripPrefix string   `yaml:"strip_prefix"` // Names local files after their path relative to the prefix
+	Exclude     []string `yaml:"exclude"`      // Ignore patterns for local directories in .psuignore syntax
+	Release     string   `yaml:"release"`      // Release archive file pattern
+	URL         string   `yaml:"url"`
+	Content     *string  `yaml:"content"` // Generated file contents, requires name
+	Repo        string   `yaml:"repo"`
+	Tag         string   `yaml:"tag"`
+	Asset       string   `yaml:"asset"`
+	SHA256      string   `yaml:"sha256"` // Expected release asset or URL file hash
+	Name        string   `yaml:"name"`   // PSU file name
+
+	node *yaml.Node
+}
+
+// Manifest validation error
+type manifestError struct {
+	line int
+	msg  string
+}
+
+func (e *manifestError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.line, e.msg)
+}
+
+func (m *manifest) UnmarshalYAML(node *yaml.Node) error {
+	type plain manifest
+	m.node = node
+	return decodeStrict(node, (*plain)(m))
+}
+
+func (p *manifestPSU) UnmarshalYAML(node *yaml.Node) error {
+	type plain manifestPSU
+	p.node = node
+	return decodeStrict(node, (*plain)(p))
+}
+
+func (f *manifestFile) UnmarshalYAML(node *yaml.Node) error {
+	type plain manifestFile
+	f.node = node
+	return decodeStrict(node, (*plain)(f))
+}
+
+// Reads and validates build manifest
+func loadManifest(name string) (*manifest, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := &manifest{}
+	err = yaml.NewDecoder(f).Decode(m)
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: manifest is empty", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return m, nil
+}
+
+func (m *manifest) validate() error {
+	if (m.SHA256 != "") && !gh.IsSHA256(m.SHA256) {
+		return lineError(m.node, "sha256", "invalid SHA-256 hash '%s'", m.SHA256)
+	}
+	if (m.PublicKey != "") && (m.PublicKeyFile != "") {
+		return lineError(m.node, "pubkey_file", "pubkey and pubkey_file are mutually exclusive")
+	}
+	if len(m.PSUs) == 0 {
+		return lineError(m.node, "psus", "at least one PSU is required")
+	}
+
+	outputs := map[string]bool{}
+	for i := range m.PSUs {
+		p := &m.PSUs[i]
+		if p.DirName == "" {
+			return lineError(p.node, "dirname", "dirname is required")
+		}
+		if p.Output == "" {
+			p.Output = p.DirName + ".psu"
+		}
+		if outputs[p.Output] {
+			return lineError(p.node, "output", "output %s is used by another PSU", p.Output)
+		}
+		outputs[p.Output] = true
+		if len(p.Files) == 0 {
+			return lineError(p.node, "files", "at least one file is required")
+		}
+		if err := checkCollisionPolicy(p.Flatten); err != nil {
+			return lineError(p.node, "flatten", "%s", err)
+		}
+
+		for _, f := range p.Files {
+			if err := f.validate(m.Repo); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *manifestFile) validate(defaultRepo string) error {
+	sources := 0
+	for _, s := range []bool{f.Local != "", f.Release != "", f.URL != "", f.Content != nil} {
+		if s {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return lineError(f.node, "", "exactly one of local, release, url and content must be set")
+	}
+
+	if (f.Local == "") && (f.StripPrefix != "") {
+		return lineError(f.node, "strip_prefix", "strip_prefix can only be set for local files")
+	}
+	if (f.Local == "") && (len(f.Exclude) != 0) {
+		return lineError(f.node, "exclude", "exclude can only be set for local files")
+	}
+	for _, p := range f.Exclude {
+		if _, _, err := parseIgnoreRule(p, ".", "exclude"); err != nil {
+			return lineError(f.node, "exclude", "%s", err)
+		}
+	}
+	if (f.Release == "") && ((f.Repo != "") || (f.Tag != "") || (f.Asset != "")) {
+		return lineError(f.node, "", "repo, tag and asset can only be set for release files")
+	}
+	if (f.Release != "") && (f.Repo == "") && (defaultRepo == "") {
+		return lineError(f.node, "release", "release file requires repo")
+	}
+	if (f.SHA256 != "") && (f.Release == "") && (f.URL == "") {
+		return lineError(f.node, "sha256", "sha256 can only be set for release and url files")
+	}
+	if (f.SHA256 != "") && !gh.IsSHA256(f.SHA256) {
+		return lineError(f.node, "sha256", "invalid SHA-256 hash '%s'", f.SHA256)
+	}
+	if f.URL != "" {
+		if u, err := url.Parse(f.URL); (err != nil) || ((u.Scheme != "http") && (u.Scheme != "https")) {
+			return lineError(f.node, "url", "invalid URL '%s'", f.URL)
+		}
+	}
+	if (f.Content != nil) && (f.Name == "") {
+		return lineError(f.node, "content", "generated file requires name")
+	}
+	if strings.ContainsAny(f.Name, "/\\") {
+		return lineError(f.node, "name", "invalid PSU file name '%s'", f.Name)
+	}
+	return nil
+}
+
+// Converts manifest PSU files into file inputs.
+// Local paths are resolved relative to dir
+func (p *manifestPSU) inputs(dir string) []fileInput {
+	inputs := make([]fileInput, 0, len(p.Files))
+	for _, f := range p.Files {
+		in := fileInput{name: f.Name, sha256: strings.ToLower(f.SHA256)}
+		switch {
+		case f.Local != "":
+			in.source, in.path = inputLocal, resolvePath(dir, f.Local)
+			in.exclude = f.Exclude
+			if (in.name == "") && (f.StripPrefix != "") {
+				in.name = stripPrefixName(in.path, resolvePath(dir, f.StripPrefix))
+			}
+		case f.Release != "":
+			in.source, in.path = inputRelease, f.Release
+			in.repo, in.tag, in.asset = f.Repo, f.Tag, f.Asset
+		case f.URL != "":
+			in.source, in.path = inputURL, f.URL
+			if in.name == "" {
+				u, _ := url.Parse(f.URL)
+				in.name = path.Base(u.Path)
+			}
+		default:
+			in.source, in.content = inputContent, []byte(*f.Content)
+		}
+		inputs = append(inputs, in)
+	}
+	return inputs
+}
+
+// Returns release settings for files that don't set their own repository
+func (m *manifest) releaseDefaults(key *gh.PublicKey) releaseDefaults {
+	return releaseDefaults{
+		repo:   m.Repo,
+		tag:    cmp.Or(m.Tag, defaultTag),
+		asset:  m.Asset,
+		sha256: strings.ToLower(m.SHA256),
+		key:    key,
+	}
+}
+
+// Returns public key set in the manifest or nil if the key is not set
+func (m *manifest) publicKey(dir string) (*gh.PublicKey, error) {
+	switch {
+	case m.PublicKey != "":
+		return gh.ParsePublicKey([]byte(m.PublicKey))
+	case m.PublicKeyFile != "":
+		data, err := os.ReadFile(resolvePath(dir, m.PublicKeyFile))
+		if err != nil {
+			return nil, err
+		}
+		return gh.ParsePublicKey(data)
+	}
+	return nil, nil
+}
+
+// Resolves path relative to dir
+func resolvePath(dir string, p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(dir, p)
+}
+
+// Decodes mapping node into the struct.
+// Fails on keys that don't match any of the struct fields
+func decodeStrict(node *yaml.Node, v any) error {
+	if node.Kind != yaml.MappingNode {
+		return &manifestError{line: node.Line, msg: "expected mapping"}
+	}
+	known := map[string]bool{}
+	t := reflect.TypeOf(v).Elem()
+	for i := range t.NumField() {
+		if name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ","); name != "" {
+			known[name] = true
+		}
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		if key := node.Content[i]; !known[key.Value] {
+			return &manifestError{line: key.Line, msg: fmt.Sprintf("unknown field '%s'", key.Value)}
+		}
+	}
+	return node.Decode(v)
+}
+
+// Returns manifestError pointing at the key of the mapping node.
+// Points at the node itself if the key is not present
+func lineError(node *yaml.Node, key string, format string, args ...any) error {
+	line := 0
+	if node != nil {
+		line = node.Line
+		for i := 0; i < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				line = node.Content[i].Line
+				break
+			}
+		}
+	}
+	return &manifestError{line: line, msg: fmt.Sprintf(format, args...)}
+}
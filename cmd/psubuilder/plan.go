@@ -0,0 +1,98 @@
+//go:build !js
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// Memory card cluster and directory entry sizes
+const (
+	clusterSize = 1024
+	entrySize   = 512
+)
+
+// Dry-run plan of the PSU build
+type plan struct {
+	Output   string        `json:"output"`
+	DirName  string        `json:"dirname"`
+	Releases []planRelease `json:"releases"`
+	Files    []planFile    `json:"files"`
+	Size     int           `json:"size"`     // Total size of all files
+	Clusters int           `json:"clusters"` // Estimated number of memory card clusters used by the directory and its files
+}
+
+// Release asset the files come from
+type planRelease struct {
+	Repo     string `json:"repo"`
+	Tag      string `json:"tag"`      // Requested tag
+	Resolved string `json:"resolved"` // Resolved release tag
+	Asset    string `json:"asset"`
+	Size     int64  `json:"size"` // 0 if unknown
+}
+
+// PSU file in the plan
+type planFile struct {
+	Name     string    `json:"name"`
+	Source   string    `json:"source"`
+	Size     int       `json:"size"`
+	Clusters int       `json:"clusters"`
+	Created  time.Time `json:"created"`
+	Modified time.Time `json:"modified"`
+}
+
+// Creates dry-run plan for files in the set
+func newPlan(output string, dirName string, set *fileSet) *plan {
+	p := &plan{
+		Output:   output,
+		DirName:  dirName,
+		Releases: set.releases,
+		Files:    make([]planFile, 0, len(set.files)),
+	}
+	if p.Releases == nil {
+		p.Releases = []planRelease{}
+	}
+	// Every directory has '.' and '..' entries in addition to file entries
+	p.Clusters = clusters((len(set.files) + 2) * entrySize)
+	for _, f := range set.files {
+		pf := planFile{
+			Name:     f.Name,
+			Source:   set.sources[f.Name],
+			Size:     len(f.Data),
+			Clusters: clusters(len(f.Data)),
+			Created:  f.Created,
+			Modified: f.Modified,
+		}
+		p.Files = append(p.Files, pf)
+		p.Size += pf.Size
+		p.Clusters += pf.Clusters
+	}
+	return p
+}
+
+// Prints plan as text
+func (p *plan) print(out io.Writer) {
+	fmt.Fprintln(out, "Output:   ", p.Output)
+	fmt.Fprintln(out, "Directory:", p.DirName)
+	for _, r := range p.Releases {
+		fmt.Fprintf(out, "Release:   %s@%s (%s), asset %s\n", r.Repo, r.Resolved, r.Tag, r.Asset)
+	}
+	fmt.Fprintln(out)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSIZE\tCREATED\tMODIFIED\tSOURCE")
+	for _, f := range p.Files {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", f.Name, f.Size, f.Created.Local().Format(time.DateTime), f.Modified.Local().Format(time.DateTime), f.Source)
+	}
+	w.Flush()
+
+	fmt.Fprintf(out, "\n%d files, %d bytes, estimated memory card usage: %d KiB (%d clusters)\n", len(p.Files), p.Size, p.Clusters*clusterSize/1024, p.Clusters)
+}
+
+// Returns number of clusters needed to store size bytes
+func clusters(size int) int {
+	return (size + clusterSize - 1) / clusterSize
+}
@@ -0,0 +1,92 @@
+//go:build !js
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pcm720/nhddl-psu/gh"
+)
+
+// Width of the progress bar in characters
+const progressBarWidth = 30
+
+// Minimum interval between redraws of downloads with unknown size
+const progressInterval = 100 * time.Millisecond
+
+// Renders release asset download progress on the terminal
+type progressBar struct {
+	w       io.Writer
+	active  bool      // Bar line hasn't been terminated yet
+	percent int64     // Last rendered percentage
+	drawn   time.Time // Last time the size of a download with unknown size was rendered
+}
+
+// Progress bar for Fetcher downloads, nil if stderr is not a terminal
+var progress = newProgressBar()
+
+// Returns progress bar writing to stderr or nil if stderr is not a terminal
+func newProgressBar() *progressBar {
+	info, err := os.Stderr.Stat()
+	if (err != nil) || (info.Mode()&os.ModeCharDevice == 0) {
+		return nil
+	}
+	return &progressBar{w: os.Stderr}
+}
+
+// Renders the download progress.
+// Other phases are logged by gh, so they only terminate the bar line
+func (b *progressBar) update(p gh.Progress) {
+	if p.Phase != gh.PhaseDownloading {
+		b.finish()
+		return
+	}
+
+	if p.Total <= 0 {
+		// Percentage can't be calculated, so the size is redrawn at most once per interval
+		if b.active && (time.Since(b.drawn) < progressInterval) {
+			return
+		}
+		b.drawn = time.Now()
+		fmt.Fprintf(b.w, "\r%s %s", p.Name, formatSize(p.Read))
+	} else {
+		percent := min(p.Read*100/p.Total, 100)
+		if b.active && (percent == b.percent) && !p.Done() {
+			return
+		}
+		b.percent = percent
+		filled := int(percent * progressBarWidth / 100)
+		fmt.Fprintf(b.w, "\r%s [%s%s] %3d%% %s / %s", p.Name,
+			strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled),
+			percent, formatSize(p.Read), formatSize(p.Total))
+	}
+	b.active = true
+	if p.Done() {
+		b.finish()
+	}
+}
+
+// Terminates the bar line
+func (b *progressBar) finish() {
+	if (b == nil) || !b.active {
+		return
+	}
+	fmt.Fprintln(b.w)
+	b.active = false
+}
+
+// Formats size in bytes as a human-readable string
+func formatSize(size int64) string {
+	switch {
+	case size >= 1024*1024:
+		return fmt.Sprintf("%.1f MiB", float64(size)/(1024*1024))
+	case size >= 1024:
+		return fmt.Sprintf("%.1f KiB", float64(size)/1024)
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
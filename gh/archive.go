@@ -0,0 +1,167 @@
+package gh
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Supported archive formats
+const (
+	FormatZIP     = "zip"
+	FormatTar     = "tar"
+	FormatTarGzip = "tar.gz"
+	FormatTarXz   = "tar.xz"
+	FormatTarZstd = "tar.zst"
+	Format7z      = "7z"
+)
+
+// Called for every archive entry.
+// open returns entry contents and is only valid during the call
+type walkFunc func(name string, modified time.Time, isDir bool, open func() (io.ReadCloser, error)) error
+
+// Walks over every archive entry
+type walker func(r io.ReaderAt, size int64, fn walkFunc) error
+
+// Maps archive format to the function that walks over it.
+// Formats that require large external decoders are registered in archive_native.go
+// to keep the WebAssembly binary small
+var walkers = map[string]walker{
+	FormatZIP:     walkZIP,
+	FormatTar:     walkTar,
+	FormatTarGzip: walkCompressedTar(func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }),
+}
+
+// Archive magic bytes
+var magics = []struct {
+	format string
+	offset int
+	magic  []byte
+}{
+	{FormatZIP, 0, []byte("PK\x03\x04")},
+	{FormatZIP, 0, []byte("PK\x05\x06")}, // Empty archive
+	{FormatTarGzip, 0, []byte{0x1f, 0x8b}},
+	{FormatTarXz, 0, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{FormatTarZstd, 0, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{Format7z, 0, []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}},
+	{FormatTar, 257, []byte("ustar")},
+}
+
+// Archive file name suffixes used if the format can't be detected by magic bytes
+var suffixes = []struct {
+	format string
+	suffix string
+}{
+	{FormatZIP, ".zip"},
+	{FormatTarGzip, ".tar.gz"},
+	{FormatTarGzip, ".tgz"},
+	{FormatTarXz, ".tar.xz"},
+	{FormatTarXz, ".txz"},
+	{FormatTarZstd, ".tar.zst"},
+	{FormatTarZstd, ".tzst"},
+	{Format7z, ".7z"},
+	{FormatTar, ".tar"},
+}
+
+// Detects archive format by magic bytes, falling back on the file name
+func detectFormat(r io.ReaderAt, size int64, name string) (string, error) {
+	header := make([]byte, min(size, 512))
+	if _, err := r.ReadAt(header, 0); (err != nil) && (err != io.EOF) {
+		return "", err
+	}
+	for _, m := range magics {
+		if (len(header) >= m.offset+len(m.magic)) && bytes.Equal(header[m.offset:m.offset+len(m.magic)], m.magic) {
+			return m.format, nil
+		}
+	}
+
+	name = strings.ToLower(name)
+	for _, s := range suffixes {
+		if strings.HasSuffix(name, s.suffix) {
+			return s.format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown archive format of %s", name)
+}
+
+// Detects archive format and walks over every archive entry
+func walkArchive(r io.ReaderAt, size int64, name string, fn walkFunc) error {
+	format, err := detectFormat(r, size, name)
+	if err != nil {
+		return err
+	}
+	walk, ok := walkers[format]
+	if !ok {
+		supported := make([]string, 0, len(walkers))
+		for f := range walkers {
+			supported = append(supported, f)
+		}
+		slices.Sort(supported)
+		return fmt.Errorf("%s archives are not supported in this build, supported formats: %s", format, strings.Join(supported, ", "))
+	}
+	logln("processing", format, "archive")
+	return walk(r, size, fn)
+}
+
+func walkZIP(r io.ReaderAt, size int64, fn walkFunc) error {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range z.File {
+		if err := fn(f.Name, f.Modified, f.FileInfo().IsDir(), f.Open); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTar(r io.ReaderAt, size int64, fn walkFunc) error {
+	return walkTarStream(io.NewSectionReader(r, 0, size), fn)
+}
+
+// Returns walker for tar archive compressed with the given decompressor
+func walkCompressedTar(decompress func(r io.Reader) (io.Reader, error)) walker {
+	return func(r io.ReaderAt, size int64, fn walkFunc) error {
+		dr, err := decompress(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return err
+		}
+		if c, ok := dr.(io.Closer); ok {
+			defer c.Close()
+		}
+		return walkTarStream(dr, fn)
+	}
+}
+
+func walkTarStream(r io.Reader, fn walkFunc) error {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch h.Typeflag {
+		case tar.TypeReg, tar.TypeDir:
+		default:
+			// Skip links and special files
+			continue
+		}
+		if err := fn(strings.TrimPrefix(h.Name, "./"), h.ModTime, h.Typeflag == tar.TypeDir, func() (io.ReadCloser, error) {
+			return io.NopCloser(tr), nil
+		}); err != nil {
+			return err
+		}
+	}
+}
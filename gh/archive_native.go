@@ -0,0 +1,37 @@
+//go:build !js
+
+package gh
+
+import (
+	"io"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Registers archive formats that are only supported in native builds
+func init() {
+	walkers[FormatTarXz] = walkCompressedTar(func(r io.Reader) (io.Reader, error) { return xz.NewReader(r) })
+	walkers[FormatTarZstd] = walkCompressedTar(func(r io.Reader) (io.Reader, error) {
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	})
+	walkers[Format7z] = walk7z
+}
+
+func walk7z(r io.ReaderAt, size int64, fn walkFunc) error {
+	z, err := sevenzip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range z.File {
+		if err := fn(f.Name, f.Modified, f.FileInfo().IsDir(), f.Open); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+//go:build !js
+
+package gh_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pcm720/nhddl-psu/gh"
+	"github.com/pcm720/nhddl-psu/gh/ghtest"
+)
+
+func TestArchiveFormats(t *testing.T) {
+	elf := make([]byte, 3000)
+	for i := range elf {
+		elf[i] = byte(i * 7 % 251)
+	}
+	// Every fixture contains the same files
+	expected := map[string][]byte{
+		"nhddl.elf": elf,
+		"icon.sys":  []byte("PS2D icon"),
+		"title.cfg": []byte("title=NHDDL\n"),
+	}
+	modified := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+
+	for _, name := range []string{"archive.zip", "archive.tar", "archive.tar.gz", "archive.tar.xz", "archive.tar.zst", "archive.7z"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := ghtest.NewServer()
+			t.Cleanup(s.Close)
+			s.AddRelease("pcm720/nhddl", ghtest.Release{Tag: "v1.0.0", Assets: []ghtest.Asset{{Name: name, Data: data}}})
+
+			files, err := gh.GetFiles(s.Fetcher("pcm720/nhddl"), "v1.0.0", []string{"nhddl.elf", "res"}, gh.FilesOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != len(expected) {
+				t.Fatalf("got %d files, expected %d", len(files), len(expected))
+			}
+			for _, f := range files {
+				if !bytes.Equal(f.Data, expected[f.Name]) {
+					t.Errorf("%s: unexpected contents", f.Name)
+				}
+				if !f.Modified.Equal(modified) {
+					t.Errorf("%s: got modification time %s, expected %s", f.Name, f.Modified, modified)
+				}
+			}
+		})
+	}
+}
+
+func TestUnknownArchiveFormat(t *testing.T) {
+	s := ghtest.NewServer()
+	t.Cleanup(s.Close)
+	s.AddRelease("pcm720/nhddl", ghtest.Release{Tag: "v1.0.0", Assets: []ghtest.Asset{{Name: "archive.bin", Data: []byte("not an archive")}}})
+	if _, err := gh.GetFiles(s.Fetcher("pcm720/nhddl"), "v1.0.0", []string{"nhddl.elf"}, gh.FilesOptions{}); err == nil {
+		t.Fatal("expected unknown archive format to be rejected")
+	}
+}
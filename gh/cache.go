@@ -0,0 +1,202 @@
+package gh
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pcm720/nhddl-psu/gh/internal/fetch"
+)
+
+// Cache is a content-addressed on-disk response cache.
+// Response bodies are stored in the blobs directory under their SHA-256 hash,
+// index entries mapping URLs to blobs are stored in the index directory
+type Cache struct {
+	Dir string
+}
+
+// Cache index entry
+type CacheEntry struct {
+	URL          string            `json:"url"`
+	ETag         string            `json:"etag,omitempty"`
+	LastModified string            `json:"last_modified,omitempty"`
+	Header       map[string]string `json:"header,omitempty"` // Response headers
+	SHA256       string            `json:"sha256"`           // Blob hash
+	Size         int64             `json:"size"`
+	Updated      time.Time         `json:"updated"` // Time of the last successful revalidation
+}
+
+// Returns cache entry for the URL or nil if the URL is not cached
+func (c *Cache) Get(url string) (*CacheEntry, error) {
+	data, err := os.ReadFile(c.indexPath(url))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &CacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Reads entry data
+func (c *Cache) Read(entry *CacheEntry) ([]byte, error) {
+	data, err := os.ReadFile(c.blobPath(entry.SHA256))
+	if err != nil {
+		return nil, err
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != entry.SHA256 {
+		return nil, errors.New("cached data is corrupted")
+	}
+	return data, nil
+}
+
+// Stores response data for the URL
+func (c *Cache) Put(url string, header fetch.Header, data []byte) (*CacheEntry, error) {
+	sum := sha256.Sum256(data)
+	entry := &CacheEntry{
+		URL:          url,
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		Header:       header,
+		SHA256:       hex.EncodeToString(sum[:]),
+		Size:         int64(len(data)),
+		Updated:      time.Now(),
+	}
+
+	// Blob is rewritten even if it exists in case it has been corrupted
+	if err := writeFileAtomic(c.blobPath(entry.SHA256), data); err != nil {
+		return nil, err
+	}
+	return entry, c.writeEntry(entry)
+}
+
+// Removes cache entry for the URL.
+// The entry data is removed by Prune once it's no longer referenced
+func (c *Cache) Delete(url string) error {
+	if err := os.Remove(c.indexPath(url)); (err != nil) && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Updates entry revalidation time
+func (c *Cache) Touch(entry *CacheEntry) error {
+	entry.Updated = time.Now()
+	return c.writeEntry(entry)
+}
+
+// Returns all cache entries
+func (c *Cache) List() ([]CacheEntry, error) {
+	dirEntries, err := os.ReadDir(filepath.Join(c.Dir, "index"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]CacheEntry, 0, len(dirEntries))
+	for _, e := range dirEntries {
+		data, err := os.ReadFile(filepath.Join(c.Dir, "index", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		entry := CacheEntry{}
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Removes entries that haven't been revalidated for the given duration and blobs that are no longer referenced.
+// Returns the number of removed entries
+func (c *Cache) Prune(olderThan time.Duration) (int, error) {
+	entries, err := c.List()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	referenced := map[string]bool{}
+	for _, e := range entries {
+		if time.Since(e.Updated) < olderThan {
+			referenced[e.SHA256] = true
+			continue
+		}
+		if err := os.Remove(c.indexPath(e.URL)); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	blobs, err := os.ReadDir(filepath.Join(c.Dir, "blobs"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return removed, nil
+	}
+	if err != nil {
+		return removed, err
+	}
+	for _, b := range blobs {
+		if !referenced[b.Name()] {
+			if err := os.Remove(filepath.Join(c.Dir, "blobs", b.Name())); err != nil {
+				return removed, err
+			}
+		}
+	}
+	return removed, nil
+}
+
+// Removes all cached data
+func (c *Cache) Clear() error {
+	return os.RemoveAll(c.Dir)
+}
+
+func (c *Cache) writeEntry(entry *CacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.indexPath(entry.URL), data)
+}
+
+func (c *Cache) indexPath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.Dir, "index", hex.EncodeToString(sum[:])+".json")
+}
+
+func (c *Cache) blobPath(sha256 string) string {
+	return filepath.Join(c.Dir, "blobs", sha256)
+}
+
+// Writes data to the temporary file and renames it to avoid leaving partially written files
+func writeFileAtomic(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
@@ -0,0 +1,72 @@
+//go:build !js
+
+package gh_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pcm720/nhddl-psu/gh"
+)
+
+func TestCacheRecovery(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(blob string) error
+	}{
+		{"corrupted", func(blob string) error { return os.WriteFile(blob, []byte("corrupted"), 0644) }},
+		{"missing", os.Remove},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, asset, data := newAssetServer(t, 1000)
+			f := s.Fetcher("pcm720/nhddl")
+			f.Cache = &gh.Cache{Dir: t.TempDir()}
+
+			// Reads the asset and checks its contents
+			read := func() {
+				t.Helper()
+				r, size, err := f.OpenAsset(asset)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := io.ReadAll(io.NewSectionReader(r, 0, size))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Fatal("asset data doesn't match")
+				}
+			}
+
+			read()
+			entries, err := f.Cache.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Fatalf("expected 1 cache entry, got %d", len(entries))
+			}
+			if err := tt.corrupt(filepath.Join(f.Cache.Dir, "blobs", entries[0].SHA256)); err != nil {
+				t.Fatal(err)
+			}
+
+			// Entry is replaced with the fresh response
+			read()
+			entry, err := f.Cache.Get(entries[0].URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry == nil {
+				t.Fatal("cache entry has not been restored")
+			}
+			if _, err := f.Cache.Read(entry); err != nil {
+				t.Fatal(err)
+			}
+			read()
+		})
+	}
+}
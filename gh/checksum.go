@@ -0,0 +1,125 @@
+package gh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Returned when asset hash doesn't match the expected hash
+type ChecksumError struct {
+	Asset    string
+	Source   string // Where the expected hash came from
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("SHA-256 mismatch for %s: expected %s (%s), got %s", e.Asset, e.Expected, e.Source, e.Actual)
+}
+
+// Expected asset hash
+type checksum struct {
+	source string
+	sum    string
+}
+
+// Collects expected SHA-256 hashes for the asset from the explicit hash,
+// the asset digest and checksum assets in the same release (SHA256SUMS or <asset name>.sha256)
+func collectChecksums(s Source, release *Release, asset Asset, explicit string) ([]checksum, error) {
+	var sums []checksum
+	if explicit != "" {
+		explicit = strings.ToLower(explicit)
+		if !IsSHA256(explicit) {
+			return nil, fmt.Errorf("invalid SHA-256 hash '%s'", explicit)
+		}
+		sums = append(sums, checksum{"provided hash", explicit})
+	}
+	if sum, ok := strings.CutPrefix(asset.Digest, "sha256:"); ok {
+		sums = append(sums, checksum{"asset digest", strings.ToLower(sum)})
+	}
+
+	for _, a := range release.Assets {
+		name := strings.ToLower(a.Name)
+		if !isChecksumsFile(name) && (name != strings.ToLower(asset.Name)+".sha256") {
+			continue
+		}
+
+		logln("getting checksums from", a.Name)
+		r, size, err := DownloadAsset(s, a)
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return nil, err
+		}
+		sum, err := findChecksum(data, asset.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", a.Name, err)
+		}
+		if sum != "" {
+			sums = append(sums, checksum{a.Name, sum})
+		}
+	}
+	return sums, nil
+}
+
+// Returns true if the lowercase asset name is a checksum file for all release assets
+func isChecksumsFile(name string) bool {
+	return (name == "sha256sums") || (name == "sha256sums.txt")
+}
+
+// Finds hash for the file in sha256sum output.
+// Lines without the file name are assumed to belong to the file.
+// Returns empty string if there's no hash for the file
+func findChecksum(data []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		sum := strings.ToLower(fields[0])
+		if !IsSHA256(sum) {
+			return "", fmt.Errorf("invalid hash '%s'", fields[0])
+		}
+		// Binary mode is marked with '*'
+		if (len(fields) == 1) || (strings.TrimPrefix(fields[1], "*") == name) {
+			return sum, nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+// Returns true if s is a hex-encoded SHA-256 hash
+func IsSHA256(s string) bool {
+	_, err := hex.DecodeString(s)
+	return (err == nil) && (len(s) == sha256.Size*2)
+}
+
+// Hashes the asset and compares the hash against all expected hashes.
+// Returns asset hash
+func verifyChecksums(r io.ReaderAt, size int64, asset Asset, sums []checksum) (string, error) {
+	if len(sums) == 0 {
+		return "", errors.New("no checksums to verify against")
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, size)); err != nil {
+		return "", err
+	}
+	actual := hex.EncodeToString(h.Sum(nil))
+
+	for _, s := range sums {
+		if s.sum != actual {
+			return "", &ChecksumError{Asset: asset.Name, Source: s.source, Expected: s.sum, Actual: actual}
+		}
+	}
+	return actual, nil
+}
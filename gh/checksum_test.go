@@ -0,0 +1,65 @@
+//go:build !js
+
+package gh_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pcm720/nhddl-psu/gh"
+	"github.com/pcm720/nhddl-psu/gh/ghtest"
+)
+
+func TestChecksumMismatch(t *testing.T) {
+	asset := ghtest.Asset{Name: "nhddl.zip", Data: ghtest.ZIP(map[string][]byte{"nhddl.elf": []byte("nhddl")})}
+	other := ghtest.Asset{Name: "nhddl.zip", Data: []byte("other")}
+	sum := sha256.Sum256(other.Data)
+	wrongSum := hex.EncodeToString(sum[:])
+	withDigest := asset
+	withDigest.Digest = "sha256:" + wrongSum
+
+	tests := []struct {
+		name    string
+		dialect ghtest.Dialect
+		assets  []ghtest.Asset
+		sha256  string
+		source  string // Expected ChecksumError source
+	}{
+		{"provided hash", ghtest.GitHub, []ghtest.Asset{asset}, wrongSum, "provided hash"},
+		{"uppercase provided hash", ghtest.Gitea, []ghtest.Asset{asset}, strings.ToUpper(wrongSum), "provided hash"},
+		{"asset digest", ghtest.GitHub, []ghtest.Asset{withDigest}, "", "asset digest"},
+		{"SHA256SUMS", ghtest.Gitea, []ghtest.Asset{asset, ghtest.SHA256SUMS(other)}, "", "SHA256SUMS"},
+		{
+			"asset checksum file", ghtest.GitLab,
+			[]ghtest.Asset{asset, {Name: "nhddl.zip.sha256", Data: []byte(wrongSum + "  nhddl.zip\n")}}, "",
+			"nhddl.zip.sha256",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := ghtest.NewDialectServer(tt.dialect)
+			t.Cleanup(s.Close)
+			s.AddRelease("pcm720/nhddl", ghtest.Release{Tag: "v1.0.0", Assets: tt.assets})
+
+			_, err := gh.GetFiles(s.Source("pcm720/nhddl"), "v1.0.0", []string{"nhddl.elf"}, gh.FilesOptions{Asset: "nhddl.zip", SHA256: tt.sha256})
+			var sumErr *gh.ChecksumError
+			if !errors.As(err, &sumErr) {
+				t.Fatalf("expected ChecksumError, got %v", err)
+			}
+			if (sumErr.Source != tt.source) || (sumErr.Expected != wrongSum) {
+				t.Errorf("unexpected error %+v", sumErr)
+			}
+		})
+	}
+}
+
+func TestInvalidProvidedHash(t *testing.T) {
+	s := newReleaseServer(t, ghtest.GitHub)
+	_, err := gh.GetFiles(s.Source("pcm720/nhddl"), "v1.0.0", []string{"nhddl.elf"}, gh.FilesOptions{SHA256: "1234"})
+	if (err == nil) || !strings.Contains(err.Error(), "invalid SHA-256") {
+		t.Fatalf("expected invalid hash error, got %v", err)
+	}
+}
@@ -1,134 +1,368 @@
 package gh
 
 import (
-	"archive/zip"
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
-	"path"
-	"slices"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pcm720/nhddl-psu/gh/internal/fetch"
 	"github.com/pcm720/psu-go"
 )
 
+// Default API URL
+const GitHubAPIURL = "https://api.github.com"
+
 type Fetcher struct {
 	Repo      string
 	CORSProxy string
+	Token     string // API token. Only sent to the API, never to asset URLs
+	// API base URL. Defaults to GitHubAPIURL.
+	// Gitea and Forgejo instances are supported too (e.g. https://codeberg.org/api/v1)
+	APIURL string
+	// Optional response cache. Cached responses are revalidated with conditional requests
+	Cache *Cache
+	// HTTP transport. Defaults to Fetch API in browsers and http.DefaultClient elsewhere
+	Transport Doer
+	// Called on GetFiles phase changes and as release asset data is downloaded
+	OnProgress func(p Progress)
+	// Retry policy for GET requests. Also used to resume interrupted asset downloads.
+	// Requests are not retried if nil, but every request is still limited by the default 30s attempt timeout
+	Retry *RetryPolicy
+}
+
+// Returned when GitHub API rate limit has been exceeded
+type RateLimitError struct {
+	Limit int       // Request limit for the current window, 0 if unknown
+	Reset time.Time // Time when the limit resets
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("API rate limit exceeded, resets at %s", e.Reset.Local().Format(time.DateTime))
 }
 
 type GHRelease struct {
-	Assets []struct {
-		BrowserDownloadURL string `json:"browser_download_url"`
-	} `json:"assets"`
+	TagName     string    `json:"tag_name"`
+	Name        string    `json:"name"`
+	PublishedAt time.Time `json:"published_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Draft       bool      `json:"draft"`
+	Prerelease  bool      `json:"prerelease"`
+	Body        string    `json:"body"`
+	Assets      []GHAsset `json:"assets"`
+}
+
+// Converts GitHub release into provider-independent Release
+func (r *GHRelease) release() Release {
+	release := Release{
+		Tag:        r.TagName,
+		Name:       r.Name,
+		Published:  r.PublishedAt,
+		Updated:    r.UpdatedAt,
+		Draft:      r.Draft,
+		Prerelease: r.Prerelease,
+		Body:       r.Body,
+		Assets:     make([]Asset, len(r.Assets)),
+	}
+	for i, a := range r.Assets {
+		release.Assets[i] = Asset{
+			Name:        a.Name,
+			Size:        a.Size,
+			ContentType: a.ContentType,
+			Digest:      a.Digest,
+			URL:         a.BrowserDownloadURL,
+		}
+	}
+	return release
+}
+
+type GHAsset struct {
+	Name               string `json:"name"`
+	Size               int64  `json:"size"`
+	ContentType        string `json:"content_type"` // Not provided by Gitea
+	Digest             string `json:"digest"`       // Not provided by Gitea and older GitHub releases
+	BrowserDownloadURL string `json:"browser_download_url"`
 }
 
 type GHTag struct {
 	Name string `json:"name"`
 }
 
-func (g *Fetcher) getReleaseURL(tag string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	resp, err := fetch.Fetch(ctx, "https://api.github.com/repos/"+g.Repo+"/releases/tags/"+tag)
+// Resolves release by tag.
+// If tag is empty, returns the latest release
+func (g *Fetcher) GetRelease(tag string) (*Release, error) {
+	releaseURL := g.repoURL() + "/releases/latest"
+	if tag != "" {
+		releaseURL = g.repoURL() + "/releases/tags/" + url.PathEscape(tag)
+	}
+
+	ctx := context.Background()
+	resp, err := g.apiGet(ctx, releaseURL)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	release := GHRelease{}
-	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
-		return "", err
+	ghRelease := &GHRelease{}
+	if err := json.NewDecoder(resp.Body).Decode(ghRelease); err != nil {
+		return nil, err
 	}
-	if len(release.Assets) < 1 {
-		return "", errors.New("no assets")
+
+	release := ghRelease.release()
+	return &release, nil
+}
+
+// Returns the release marked as latest
+func (g *Fetcher) GetLatestRelease() (*Release, error) {
+	return g.GetRelease("")
+}
+
+// Returns up to limit releases, newest first. If limit is 0, returns all releases
+func (g *Fetcher) GetReleases(limit int) ([]Release, error) {
+	ghReleases, err := collectPages[GHRelease](limit, g.repoURL()+"/releases?per_page=100&limit=100", g.apiGet)
+	if err != nil {
+		return nil, err
 	}
 
-	return release.Assets[0].BrowserDownloadURL, nil
+	releases := make([]Release, len(ghReleases))
+	for i, r := range ghReleases {
+		releases[i] = r.release()
+	}
+	return releases, nil
 }
 
+// Returns all repository tags
 func (g *Fetcher) GetAllTags() ([]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	resp, err := fetch.Fetch(ctx, "https://api.github.com/repos/"+g.Repo+"/tags")
+	return g.GetTags(0)
+}
+
+// Returns up to limit repository tags, following pagination links.
+// If limit is 0, returns all tags
+func (g *Fetcher) GetTags(limit int) ([]string, error) {
+	// GitHub uses per_page and Gitea uses limit to set the page size
+	tags, err := collectPages[GHTag](limit, g.repoURL()+"/tags?per_page=100&limit=100", g.apiGet)
 	if err != nil {
 		return nil, err
 	}
+	return tagNames(tags), nil
+}
 
-	tags := []GHTag{}
-	if err := json.NewDecoder(resp.Body).Decode(&tags); (err != nil) && (err != io.EOF) {
-		return nil, err
+func tagNames(tags []GHTag) []string {
+	names := make([]string, len(tags))
+	for i, t := range tags {
+		names[i] = t.Name
 	}
+	return names
+}
 
-	tagNames := make([]string, len(tags))
-	for i, tag := range tags {
-		tagNames[i] = tag.Name
+// Collects up to limit items from all pages, starting with the first page URL.
+// If limit is 0, returns all items
+func collectPages[T any](limit int, next string, apiGet func(ctx context.Context, url string) (*fetch.FetchResponse, error)) ([]T, error) {
+	items := []T{}
+	for next != "" {
+		page, nextPage, err := getPage[T](next, apiGet)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page {
+			items = append(items, item)
+			if (limit > 0) && (len(items) == limit) {
+				return items, nil
+			}
+		}
+		next = nextPage
 	}
 
-	return tagNames, nil
+	return items, nil
 }
 
-// Downloads files from the first GitHub release asset ZIP
-func (g *Fetcher) GetFiles(tag string, targetFiles []string) ([]psu.File, error) {
-	fmt.Println("getting release ZIP for", tag)
-	rel, err := g.getReleaseURL(tag)
+// Gets a single page of items and returns it along with the next page URL
+func getPage[T any](url string, apiGet func(ctx context.Context, url string) (*fetch.FetchResponse, error)) ([]T, string, error) {
+	ctx := context.Background()
+	resp, err := apiGet(ctx, url)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	items := []T{}
+	if err := json.NewDecoder(resp.Body).Decode(&items); (err != nil) && (err != io.EOF) {
+		return nil, "", err
+	}
+
+	return items, nextPageURL(resp.Header.Get("Link")), nil
+}
+
+// Returns API URL for the repository
+func (g *Fetcher) repoURL() string {
+	apiURL := g.APIURL
+	if apiURL == "" {
+		apiURL = GitHubAPIURL
 	}
+	return strings.TrimSuffix(apiURL, "/") + "/repos/" + g.Repo
+}
 
-	rel = g.CORSProxy + rel
-	fmt.Println("downloading", rel)
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-	defer cancel()
+// Performs GET request to the API, adding the token if set
+func (g *Fetcher) apiGet(ctx context.Context, url string) (*fetch.FetchResponse, error) {
+	header := fetch.Header{}
+	header.Set("Accept", "application/vnd.github+json")
+	if g.Token != "" {
+		// Both GitHub and Gitea accept this scheme
+		header.Set("Authorization", "token "+g.Token)
+	}
+	return g.getJSON(ctx, url, header)
+}
 
-	resp, err := fetch.Fetch(ctx, rel)
+// Performs GET request with given headers and checks the response status.
+// Returns RateLimitError if the rate limit has been exceeded
+func (g *Fetcher) getJSON(ctx context.Context, url string, header fetch.Header) (*fetch.FetchResponse, error) {
+	resp, err := g.get(ctx, url, header)
 	if err != nil {
 		return nil, err
 	}
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("invalid status code %d", resp.StatusCode)
+	if resp.StatusCode == 200 {
+		return resp, nil
 	}
+	resp.Body.Close()
 
-	zipData, err := io.ReadAll(resp.Body)
-	if err != nil {
+	if err := rateLimitError(resp); err != nil {
 		return nil, err
 	}
+	return nil, fmt.Errorf("invalid status code %d", resp.StatusCode)
+}
+
+// Performs GET request, using the cache if set.
+// Cached responses are revalidated with If-None-Match and If-Modified-Since headers
+// and served from the cache if the server responds with 304 Not Modified
+func (g *Fetcher) get(ctx context.Context, url string, header fetch.Header) (*fetch.FetchResponse, error) {
+	if g.Cache == nil {
+		resp, err := g.doGet(ctx, url, header)
+		if err != nil {
+			return nil, err
+		}
+		g.wrapAssetBody(ctx, url, header, resp)
+		return resp, nil
+	}
 
-	fmt.Println("opening file", rel)
-	z, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+	entry, err := g.Cache.Get(url)
 	if err != nil {
-		return nil, err
+		logln("failed to read cache entry:", err)
+	}
+	reqHeader := fetch.Header{}
+	for k, v := range header {
+		reqHeader[k] = v
+	}
+	if entry != nil {
+		if entry.ETag != "" {
+			reqHeader.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			reqHeader.Set("If-Modified-Since", entry.LastModified)
+		}
 	}
 
-	fmt.Println("processing ZIP archive")
-	out := make([]psu.File, 0, len(targetFiles))
-	for _, f := range z.File {
-		if !f.FileInfo().IsDir() && slices.Contains(targetFiles, f.Name) {
-			fmt.Println("adding", f.Name)
+	resp, err := g.doGet(ctx, url, reqHeader)
+	if err != nil {
+		return nil, err
+	}
+	g.wrapAssetBody(ctx, url, reqHeader, resp)
 
-			file, err := f.Open()
-			if err != nil {
-				return nil, err
-			}
-			data, err := io.ReadAll(file)
-			file.Close()
-			if err != nil {
+	switch {
+	case (resp.StatusCode == 304) && (entry != nil):
+		resp.Body.Close()
+		data, err := g.Cache.Read(entry)
+		if err != nil {
+			// Cached data is corrupted or missing, request the whole response again
+			logln("failed to read cached response for", url+":", err)
+			if err := g.Cache.Delete(url); err != nil {
 				return nil, err
 			}
+			return g.get(ctx, url, header)
+		}
+		if err := g.Cache.Touch(entry); err != nil {
+			logln("failed to update cache entry:", err)
+		}
+		logln("using cached response for", url)
+		cached := &fetch.FetchResponse{
+			StatusCode:    200,
+			Header:        entry.Header,
+			Body:          io.NopCloser(bytes.NewReader(data)),
+			URL:           resp.URL,
+			ContentLength: int64(len(data)),
+		}
+		g.trackProgress(ctx, cached)
+		return cached, nil
+	case resp.StatusCode == 200:
+		if (resp.Header.Get("ETag") == "") && (resp.Header.Get("Last-Modified") == "") {
+			// Response can't be revalidated
+			return resp, nil
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := g.Cache.Put(url, resp.Header, data); err != nil {
+			logln("failed to write cache entry:", err)
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+	}
+	return resp, nil
+}
+
+// Parses rate limit headers and returns RateLimitError if the limit has been exceeded
+// See https://docs.github.com/en/rest/using-the-rest-api/rate-limits-for-the-rest-api
+// and https://docs.gitlab.com/ee/administration/settings/user_and_ip_rate_limits.html
+func rateLimitError(resp *fetch.FetchResponse) error {
+	if (resp.StatusCode != 403) && (resp.StatusCode != 429) {
+		return nil
+	}
+
+	if retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		// Secondary rate limit
+		return &RateLimitError{Reset: time.Now().Add(time.Duration(retryAfter) * time.Second)}
+	}
 
-			out = append(out, psu.File{
-				Name:     path.Base(f.Name),
-				Created:  f.Modified,
-				Modified: f.Modified,
-				Data:     data,
-			})
+	// GitLab uses headers without the X- prefix
+	for _, prefix := range []string{"X-RateLimit-", "RateLimit-"} {
+		if resp.Header.Get(prefix+"Remaining") != "0" {
+			continue
 		}
+
+		rlErr := &RateLimitError{}
+		rlErr.Limit, _ = strconv.Atoi(resp.Header.Get(prefix + "Limit"))
+		if reset, err := strconv.ParseInt(resp.Header.Get(prefix+"Reset"), 10, 64); err == nil {
+			rlErr.Reset = time.Unix(reset, 0)
+		}
+		return rlErr
 	}
-	if len(out) == 0 {
-		return nil, errors.New("no files found")
+	return nil
+}
+
+// Parses the Link header and returns URL with rel="next"
+// See https://docs.github.com/en/rest/using-the-rest-api/using-pagination-in-the-rest-api
+func nextPageURL(link string) string {
+	for _, l := range strings.Split(link, ",") {
+		parts := strings.Split(l, ";")
+		if len(parts) < 2 {
+			continue
+		}
+		for _, p := range parts[1:] {
+			if strings.TrimSpace(p) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(parts[0]), "<>")
+			}
+		}
 	}
-	return out, nil
+	return ""
+}
+
+// Downloads files from the release asset archive (ZIP, tar, tar.gz, tar.xz, tar.zst or 7z).
+// See GetFiles
+func (g *Fetcher) GetFiles(tag string, targetFiles []string, opts FilesOptions) ([]psu.File, error) {
+	return GetFiles(g, tag, targetFiles, opts)
 }
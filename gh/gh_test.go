@@ -0,0 +1,68 @@
+//go:build !js
+
+package gh_test
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/pcm720/nhddl-psu/gh/ghtest"
+)
+
+func TestGetTags(t *testing.T) {
+	const count = 150
+	expected := make([]string, count)
+	for i := range count {
+		// Newest tags come first
+		expected[count-1-i] = fmt.Sprintf("v1.0.%d", i)
+	}
+
+	for _, d := range dialects {
+		t.Run(d.String(), func(t *testing.T) {
+			s := ghtest.NewDialectServer(d)
+			defer s.Close()
+			for i := range count {
+				s.AddRelease("pcm720/nhddl", ghtest.Release{Tag: fmt.Sprintf("v1.0.%d", i)})
+			}
+			src := s.Source("pcm720/nhddl")
+
+			tags, err := src.GetTags(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(tags, expected) {
+				t.Fatalf("got %d tags %v, expected %d tags", len(tags), tags, count)
+			}
+			if s.Requests() < 2 {
+				t.Errorf("expected tags to be served in multiple pages, got %d requests", s.Requests())
+			}
+
+			for _, limit := range []int{1, 100, 120, count, count + 1} {
+				tags, err := src.GetTags(limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(tags, expected[:min(limit, count)]) {
+					t.Errorf("limit %d: got %d tags, expected %d", limit, len(tags), min(limit, count))
+				}
+			}
+		})
+	}
+}
+
+func TestGetTagsLimitRequests(t *testing.T) {
+	s := ghtest.NewServer()
+	defer s.Close()
+	for i := range 250 {
+		s.AddRelease("pcm720/nhddl", ghtest.Release{Tag: fmt.Sprintf("v1.0.%d", i)})
+	}
+
+	// The third page must not be requested
+	if _, err := s.Fetcher("pcm720/nhddl").GetTags(150); err != nil {
+		t.Fatal(err)
+	}
+	if s.Requests() != 2 {
+		t.Errorf("expected 2 requests, got %d", s.Requests())
+	}
+}
@@ -0,0 +1,501 @@
+//go:build !js
+
+// Package ghtest implements an in-process fake GitHub, Gitea and GitLab API and release asset server
+package ghtest
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pcm720/nhddl-psu/gh"
+)
+
+// Release served by Server
+type Release struct {
+	Tag        string
+	Name       string
+	Published  time.Time // Defaults to the time the release was added
+	Draft      bool
+	Prerelease bool
+	Body       string
+	Assets     []Asset
+}
+
+// Release asset served by Server
+type Asset struct {
+	Name        string
+	ContentType string // Defaults to application/octet-stream
+	Data        []byte
+	Digest      string // GitHub asset digest, defaults to the SHA-256 digest of Data
+}
+
+// API dialect served by Server
+type Dialect int
+
+const (
+	GitHub Dialect = iota // GitHub REST API
+	Gitea                 // Gitea and Forgejo API under /api/v1. Pages are limited to 50 items and assets have no content type and digest
+	GitLab                // GitLab REST API under /api/v4
+)
+
+func (d Dialect) String() string {
+	switch d {
+	case Gitea:
+		return "gitea"
+	case GitLab:
+		return "gitlab"
+	}
+	return "github"
+}
+
+// Fake release API and asset server.
+// Serves releases, latest release, tags and assets. Assets support Range requests.
+// Failures can be injected with Fail and Truncate
+type Server struct {
+	*httptest.Server
+	// If set, API requests must have 'Authorization: token <Token>' header ('PRIVATE-TOKEN: <Token>' for GitLab)
+	Token string
+
+	dialect  Dialect
+	mu       sync.Mutex
+	releases map[string][]Release // Maps owner/repo to releases, newest first
+	requests int
+	failures []int // Status codes for the next requests
+	truncate int   // Number of the next asset responses to truncate
+}
+
+// Starts GitHub API server
+func NewServer() *Server {
+	return NewDialectServer(GitHub)
+}
+
+// Starts the server with the API dialect
+func NewDialectServer(d Dialect) *Server {
+	s := &Server{dialect: d, releases: map[string][]Release{}}
+	mux := http.NewServeMux()
+	switch d {
+	case GitHub, Gitea:
+		repo := s.apiPath() + "/repos/{owner}/{repo}"
+		mux.HandleFunc("GET "+repo+"/releases", s.handleReleases)
+		mux.HandleFunc("GET "+repo+"/releases/latest", s.handleLatestRelease)
+		mux.HandleFunc("GET "+repo+"/releases/tags/{tag}", s.handleRelease)
+		mux.HandleFunc("GET "+repo+"/tags", s.handleTags)
+	case GitLab:
+		project := s.apiPath() + "/projects/{project}"
+		mux.HandleFunc("GET "+project+"/releases", s.handleReleases)
+		mux.HandleFunc("GET "+project+"/releases/permalink/latest", s.handleLatestRelease)
+		mux.HandleFunc("GET "+project+"/releases/{tag}", s.handleRelease)
+		mux.HandleFunc("GET "+project+"/repository/tags", s.handleTags)
+	}
+	mux.HandleFunc("GET /assets/{owner}/{repo}/{tag}/{name}", s.handleAsset)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests++
+		status := 0
+		if len(s.failures) > 0 {
+			status, s.failures = s.failures[0], s.failures[1:]
+		}
+		s.mu.Unlock()
+		if status != 0 {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	return s
+}
+
+// Makes the server respond to the next requests with given status codes (e.g. 502)
+func (s *Server) Fail(status ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, status...)
+}
+
+// Makes the server send only the first half of the next n asset responses
+func (s *Server) Truncate(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.truncate += n
+}
+
+// Adds release to the repository (owner/repo). Releases added later are considered newer
+func (s *Server) AddRelease(repo string, r Release) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Published.IsZero() {
+		r.Published = time.Now().UTC().Truncate(time.Second)
+	}
+	s.releases[repo] = append([]Release{r}, s.releases[repo]...)
+}
+
+// Returns number of requests served
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// Returns Fetcher for the repository that uses the server as the API
+func (s *Server) Fetcher(repo string) *gh.Fetcher {
+	return &gh.Fetcher{
+		Repo:      repo,
+		Token:     s.Token,
+		APIURL:    s.URL + s.apiPath(),
+		Transport: gh.HTTPTransport(s.Client()),
+	}
+}
+
+// Returns release source for the repository that uses the server as the API.
+// Returns gh.GitLab for GitLab servers and Fetcher otherwise
+func (s *Server) Source(repo string) gh.Source {
+	if s.dialect == GitLab {
+		return &gh.GitLab{Fetcher: *s.Fetcher(repo)}
+	}
+	return s.Fetcher(repo)
+}
+
+// Returns API base path
+func (s *Server) apiPath() string {
+	switch s.dialect {
+	case Gitea:
+		return "/api/v1"
+	case GitLab:
+		return "/api/v4"
+	}
+	return ""
+}
+
+// Returns owner/repo for the request path
+func repoName(r *http.Request) string {
+	if project := r.PathValue("project"); project != "" {
+		return project
+	}
+	return r.PathValue("owner") + "/" + r.PathValue("repo")
+}
+
+// Returns ZIP archive with the files.
+// Maps slash-separated file paths to file contents
+func ZIP(files map[string][]byte) []byte {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for _, name := range names {
+		f, err := w.Create(name)
+		if err != nil {
+			panic(err)
+		}
+		f.Write(files[name])
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// Returns SHA256SUMS asset for the assets
+func SHA256SUMS(assets ...Asset) Asset {
+	b := strings.Builder{}
+	for _, a := range assets {
+		sum := sha256.Sum256(a.Data)
+		b.WriteString(hex.EncodeToString(sum[:]) + "  " + a.Name + "\n")
+	}
+	return Asset{Name: "SHA256SUMS", ContentType: "text/plain", Data: []byte(b.String())}
+}
+
+// Returns repository releases. Writes the error response and returns false if the repository is not found or the request is not authorized
+func (s *Server) repoReleases(w http.ResponseWriter, r *http.Request) ([]Release, bool) {
+	authorized := r.Header.Get("Authorization") == "token "+s.Token
+	if s.dialect == GitLab {
+		authorized = r.Header.Get("PRIVATE-TOKEN") == s.Token
+	}
+	if (s.Token != "") && !authorized {
+		http.Error(w, `{"message":"Bad credentials"}`, http.StatusUnauthorized)
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	releases, ok := s.releases[repoName(r)]
+	if !ok {
+		http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+		return nil, false
+	}
+	return slices.Clone(releases), true
+}
+
+func (s *Server) handleReleases(w http.ResponseWriter, r *http.Request) {
+	releases, ok := s.repoReleases(w, r)
+	if !ok {
+		return
+	}
+	items := make([]any, len(releases))
+	for i, release := range releases {
+		items[i] = s.apiRelease(repoName(r), release)
+	}
+	writePage(w, r, s.dialect, items)
+}
+
+func (s *Server) handleLatestRelease(w http.ResponseWriter, r *http.Request) {
+	releases, ok := s.repoReleases(w, r)
+	if !ok {
+		return
+	}
+	for _, release := range releases {
+		// GitHub considers the newest non-draft non-prerelease release to be the latest one.
+		// GitLab has no drafts and prereleases
+		if (s.dialect == GitLab) || (!release.Draft && !release.Prerelease) {
+			writeJSON(w, r, s.apiRelease(repoName(r), release))
+			return
+		}
+	}
+	http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+}
+
+func (s *Server) handleRelease(w http.ResponseWriter, r *http.Request) {
+	releases, ok := s.repoReleases(w, r)
+	if !ok {
+		return
+	}
+	for _, release := range releases {
+		if release.Tag == r.PathValue("tag") {
+			writeJSON(w, r, s.apiRelease(repoName(r), release))
+			return
+		}
+	}
+	http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+}
+
+func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
+	releases, ok := s.repoReleases(w, r)
+	if !ok {
+		return
+	}
+	tags := make([]gh.GHTag, len(releases))
+	for i, release := range releases {
+		tags[i] = gh.GHTag{Name: release.Tag}
+	}
+	writePage(w, r, s.dialect, tags)
+}
+
+// Serves asset data. Doesn't require the token, like GitHub download URLs
+func (s *Server) handleAsset(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	releases := s.releases[repoName(r)]
+	s.mu.Unlock()
+	for _, release := range releases {
+		if release.Tag != r.PathValue("tag") {
+			continue
+		}
+		for _, a := range release.Assets {
+			if a.Name == r.PathValue("name") {
+				s.mu.Lock()
+				if s.truncate > 0 {
+					s.truncate--
+					w = &truncatingWriter{ResponseWriter: w, remaining: -1}
+				}
+				s.mu.Unlock()
+				w.Header().Set("Content-Type", contentType(a))
+				http.ServeContent(w, r, a.Name, release.Published, bytes.NewReader(a.Data))
+				return
+			}
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// truncatingWriter writes only the first half of the response body
+type truncatingWriter struct {
+	http.ResponseWriter
+	remaining int // Number of bytes left to write, -1 until the first write
+}
+
+func (w *truncatingWriter) Write(p []byte) (int, error) {
+	if w.remaining < 0 {
+		size, _ := strconv.Atoi(w.Header().Get("Content-Length"))
+		w.remaining = size / 2
+	}
+	n, err := w.ResponseWriter.Write(p[:min(len(p), w.remaining)])
+	w.remaining -= n
+	if err != nil {
+		return n, err
+	}
+	// Pretend the whole body has been written, the server closes the connection after the handler returns
+	return len(p), nil
+}
+
+// Converts release into the API release of the server dialect
+func (s *Server) apiRelease(repo string, r Release) any {
+	switch s.dialect {
+	case Gitea:
+		return s.giteaRelease(repo, r)
+	case GitLab:
+		return s.glRelease(repo, r)
+	}
+	return s.ghRelease(repo, r)
+}
+
+// Converts release into GitHub API release
+func (s *Server) ghRelease(repo string, r Release) gh.GHRelease {
+	release := gh.GHRelease{
+		TagName:     r.Tag,
+		Name:        r.Name,
+		PublishedAt: r.Published,
+		UpdatedAt:   r.Published,
+		Draft:       r.Draft,
+		Prerelease:  r.Prerelease,
+		Body:        r.Body,
+		Assets:      make([]gh.GHAsset, len(r.Assets)),
+	}
+	for i, a := range r.Assets {
+		digest := a.Digest
+		if digest == "" {
+			sum := sha256.Sum256(a.Data)
+			digest = "sha256:" + hex.EncodeToString(sum[:])
+		}
+		release.Assets[i] = gh.GHAsset{
+			Name:               a.Name,
+			Size:               int64(len(a.Data)),
+			ContentType:        contentType(a),
+			Digest:             digest,
+			BrowserDownloadURL: s.assetURL(repo, r, a),
+		}
+	}
+	return release
+}
+
+// Gitea release. Gitea doesn't provide update time, asset content type and digest
+type giteaRelease struct {
+	TagName     string       `json:"tag_name"`
+	Name        string       `json:"name"`
+	PublishedAt time.Time    `json:"published_at"`
+	Draft       bool         `json:"draft"`
+	Prerelease  bool         `json:"prerelease"`
+	Body        string       `json:"body"`
+	Assets      []giteaAsset `json:"assets"`
+}
+
+type giteaAsset struct {
+	Name               string `json:"name"`
+	Size               int64  `json:"size"`
+	BrowserDownloadURL string `json:"browser_download_url"`
+}
+
+// Converts release into Gitea API release
+func (s *Server) giteaRelease(repo string, r Release) giteaRelease {
+	release := giteaRelease{
+		TagName:     r.Tag,
+		Name:        r.Name,
+		PublishedAt: r.Published,
+		Draft:       r.Draft,
+		Prerelease:  r.Prerelease,
+		Body:        r.Body,
+		Assets:      make([]giteaAsset, len(r.Assets)),
+	}
+	for i, a := range r.Assets {
+		release.Assets[i] = giteaAsset{
+			Name:               a.Name,
+			Size:               int64(len(a.Data)),
+			BrowserDownloadURL: s.assetURL(repo, r, a),
+		}
+	}
+	return release
+}
+
+// Converts release into GitLab API release. Assets are served as release links
+func (s *Server) glRelease(repo string, r Release) gh.GLRelease {
+	release := gh.GLRelease{
+		TagName:     r.Tag,
+		Name:        r.Name,
+		Description: r.Body,
+		ReleasedAt:  r.Published,
+	}
+	release.Assets.Links = make([]gh.GLLink, len(r.Assets))
+	for i, a := range r.Assets {
+		release.Assets.Links[i] = gh.GLLink{
+			Name:           a.Name,
+			URL:            s.assetURL(repo, r, a),
+			DirectAssetURL: s.assetURL(repo, r, a),
+		}
+	}
+	return release
+}
+
+// Returns asset download URL
+func (s *Server) assetURL(repo string, r Release, a Asset) string {
+	return s.URL + "/assets/" + repo + "/" + url.PathEscape(r.Tag) + "/" + url.PathEscape(a.Name)
+}
+
+func contentType(a Asset) string {
+	if a.ContentType == "" {
+		return "application/octet-stream"
+	}
+	return a.ContentType
+}
+
+// Writes a page of items selected by page and per_page (limit for Gitea) query parameters.
+// Adds Link header with the next page URL if there are more items
+func writePage[T any](w http.ResponseWriter, r *http.Request, d Dialect, items []T) {
+	param, defaultSize, maxSize := "per_page", 30, 100
+	switch d {
+	case Gitea:
+		// Default MAX_RESPONSE_ITEMS
+		param, defaultSize, maxSize = "limit", 30, 50
+	case GitLab:
+		defaultSize = 20
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get(param))
+	if (err != nil) || (perPage <= 0) {
+		perPage = defaultSize
+	}
+	perPage = min(perPage, maxSize)
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if (err != nil) || (page <= 0) {
+		page = 1
+	}
+
+	start := min((page-1)*perPage, len(items))
+	end := min(start+perPage, len(items))
+	if end < len(items) {
+		next := *r.URL
+		q := next.Query()
+		q.Set("page", strconv.Itoa(page+1))
+		next.RawQuery = q.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<http://%s%s>; rel="next"`, r.Host, next.RequestURI()))
+	}
+	writeJSON(w, r, items[start:end])
+}
+
+// Writes v as JSON with ETag. Responds with 304 if the request has a matching If-None-Match header
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
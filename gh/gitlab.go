@@ -0,0 +1,143 @@
+package gh
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pcm720/nhddl-psu/gh/internal/fetch"
+	"github.com/pcm720/psu-go"
+)
+
+// Default GitLab API URL
+const GitLabAPIURL = "https://gitlab.com/api/v4"
+
+// GitLab release source.
+// Repo is the project path (e.g. group/project) or numeric project ID.
+// APIURL defaults to GitLabAPIURL
+type GitLab struct {
+	Fetcher
+}
+
+type GLRelease struct {
+	TagName     string    `json:"tag_name"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	ReleasedAt  time.Time `json:"released_at"`
+	Assets      struct {
+		Links []GLLink `json:"links"`
+	} `json:"assets"`
+}
+
+type GLLink struct {
+	Name           string `json:"name"`
+	URL            string `json:"url"`
+	DirectAssetURL string `json:"direct_asset_url"`
+}
+
+// Converts GitLab release into provider-independent Release.
+// GitLab has no draft and prerelease flags
+func (r *GLRelease) release() Release {
+	release := Release{
+		Tag:       r.TagName,
+		Name:      r.Name,
+		Published: r.ReleasedAt,
+		Body:      r.Description,
+		Assets:    make([]Asset, len(r.Assets.Links)),
+	}
+	for i, a := range r.Assets.Links {
+		release.Assets[i] = Asset{
+			Name: a.Name,
+			URL:  a.DirectAssetURL,
+		}
+		if release.Assets[i].URL == "" {
+			release.Assets[i].URL = a.URL
+		}
+	}
+	return release
+}
+
+// Resolves release by tag.
+// If tag is empty, returns the latest release
+func (l *GitLab) GetRelease(tag string) (*Release, error) {
+	releaseURL := l.projectURL() + "/releases/permalink/latest"
+	if tag != "" {
+		releaseURL = l.projectURL() + "/releases/" + url.PathEscape(tag)
+	}
+
+	ctx := context.Background()
+	resp, err := l.apiGet(ctx, releaseURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	glRelease := &GLRelease{}
+	if err := json.NewDecoder(resp.Body).Decode(glRelease); err != nil {
+		return nil, err
+	}
+
+	release := glRelease.release()
+	return &release, nil
+}
+
+// Returns the latest release
+func (l *GitLab) GetLatestRelease() (*Release, error) {
+	return l.GetRelease("")
+}
+
+// Returns up to limit releases, newest first. If limit is 0, returns all releases
+func (l *GitLab) GetReleases(limit int) ([]Release, error) {
+	glReleases, err := collectPages[GLRelease](limit, l.projectURL()+"/releases?per_page=100", l.apiGet)
+	if err != nil {
+		return nil, err
+	}
+
+	releases := make([]Release, len(glReleases))
+	for i, r := range glReleases {
+		releases[i] = r.release()
+	}
+	return releases, nil
+}
+
+// Returns all repository tags
+func (l *GitLab) GetAllTags() ([]string, error) {
+	return l.GetTags(0)
+}
+
+// Returns up to limit repository tags, following pagination links.
+// If limit is 0, returns all tags
+func (l *GitLab) GetTags(limit int) ([]string, error) {
+	// GitLab tags have the same name field
+	tags, err := collectPages[GHTag](limit, l.projectURL()+"/repository/tags?per_page=100", l.apiGet)
+	if err != nil {
+		return nil, err
+	}
+	return tagNames(tags), nil
+}
+
+// Downloads files from the release asset archive (ZIP, tar, tar.gz, tar.xz, tar.zst or 7z).
+// See GetFiles
+func (l *GitLab) GetFiles(tag string, targetFiles []string, opts FilesOptions) ([]psu.File, error) {
+	return GetFiles(l, tag, targetFiles, opts)
+}
+
+// Returns API URL for the project
+func (l *GitLab) projectURL() string {
+	apiURL := l.APIURL
+	if apiURL == "" {
+		apiURL = GitLabAPIURL
+	}
+	return strings.TrimSuffix(apiURL, "/") + "/projects/" + url.PathEscape(l.Repo)
+}
+
+// Performs GET request to the API, adding the token if set
+func (l *GitLab) apiGet(ctx context.Context, url string) (*fetch.FetchResponse, error) {
+	header := fetch.Header{}
+	if l.Token != "" {
+		header.Set("PRIVATE-TOKEN", l.Token)
+	}
+	return l.getJSON(ctx, url, header)
+}
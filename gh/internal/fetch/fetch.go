@@ -3,24 +3,72 @@
 package fetch
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strings"
 )
 
 // Implements fetch opreation for non-JS architectures
-func Fetch(ctx context.Context, url string) (*FetchResponse, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+func Do(ctx context.Context, r *Request) (*FetchResponse, error) {
+	return DoClient(ctx, http.DefaultClient, r)
+}
+
+// Performs request using the client
+func DoClient(ctx context.Context, client *http.Client, r *Request) (*FetchResponse, error) {
+	method := r.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if r.Body != nil {
+		body = bytes.NewReader(r.Body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, r.URL, body)
 	if err != nil {
 		return nil, err
 	}
+	for k, v := range r.Header {
+		req.Header.Set(k, v)
+	}
+
+	switch r.Redirect {
+	case "", RedirectFollow:
+	case RedirectError:
+		c := *client
+		c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return errors.New("redirects are not allowed")
+		}
+		client = &c
+	case RedirectManual:
+		c := *client
+		c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+		client = &c
+	default:
+		return nil, fmt.Errorf("unknown redirect policy '%s'", r.Redirect)
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 
+	respHeader := make(Header, len(resp.Header))
+	for k, v := range resp.Header {
+		respHeader.Set(k, strings.Join(v, ", "))
+	}
+
 	return &FetchResponse{
-		StatusCode: resp.StatusCode,
-		Body:       resp.Body,
+		StatusCode:    resp.StatusCode,
+		Header:        respHeader,
+		Body:          resp.Body,
+		URL:           resp.Request.URL.String(),
+		ContentLength: resp.ContentLength,
 	}, nil
 }
@@ -15,13 +15,18 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
 	"syscall/js"
 )
 
 var uint8Array = js.Global().Get("Uint8Array")
 
-// Implements fetch for WebAssembly using Fetch API
-func Fetch(ctx context.Context, url string) (*FetchResponse, error) {
+// Implements fetch for WebAssembly using Fetch API.
+// The request and reads from the response body are aborted when ctx is done
+func Do(ctx context.Context, r *Request) (*FetchResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	ac := js.Global().Get("AbortController")
 	if !ac.IsUndefined() {
 		// Some browsers that support WASM don't necessarily support
@@ -31,8 +36,33 @@ func Fetch(ctx context.Context, url string) (*FetchResponse, error) {
 	}
 
 	opt := js.Global().Get("Object").New()
+	if !ac.IsUndefined() {
+		opt.Set("signal", ac.Get("signal"))
+	}
+	if r.Method != "" {
+		opt.Set("method", r.Method)
+	}
+	switch r.Redirect {
+	case "":
+	case RedirectFollow, RedirectError, RedirectManual:
+		opt.Set("redirect", r.Redirect)
+	default:
+		return nil, fmt.Errorf("unknown redirect policy '%s'", r.Redirect)
+	}
+	if len(r.Header) > 0 {
+		headers := js.Global().Get("Headers").New()
+		for k, v := range r.Header {
+			headers.Call("append", k, v)
+		}
+		opt.Set("headers", headers)
+	}
+	if r.Body != nil {
+		body := uint8Array.New(len(r.Body))
+		js.CopyBytesToJS(body, r.Body)
+		opt.Set("body", body)
+	}
 
-	fetchPromise := js.Global().Call("fetch", url, opt)
+	fetchPromise := js.Global().Call("fetch", r.URL, opt)
 	var (
 		respCh           = make(chan *FetchResponse, 1)
 		errCh            = make(chan error, 1)
@@ -49,18 +79,38 @@ func Fetch(ctx context.Context, url string) (*FetchResponse, error) {
 		// The body is undefined when the browser does not support streaming response bodies (Firefox),
 		// and null in certain error cases, i.e. when the request is blocked because of CORS settings.
 		if !b.IsUndefined() && !b.IsNull() {
-			body = &streamReader{stream: b.Call("getReader")}
+			body = &streamReader{stream: b.Call("getReader"), ctx: ctx, ac: ac}
 		} else {
 			// Fall back to using ArrayBuffer
 			// https://developer.mozilla.org/en-US/docs/Web/API/Body/arrayBuffer
-			body = &arrayReader{arrayPromise: result.Call("arrayBuffer")}
+			body = &arrayReader{arrayPromise: result.Call("arrayBuffer"), ctx: ctx, ac: ac}
 		}
 
 		code := result.Get("status").Int()
 
+		header := Header{}
+		// https://developer.mozilla.org/en-US/docs/Web/API/Headers/entries
+		headersIt := result.Get("headers").Call("entries")
+		for {
+			n := headersIt.Call("next")
+			if n.Get("done").Bool() {
+				break
+			}
+			pair := n.Get("value")
+			header.Set(pair.Index(0).String(), pair.Index(1).String())
+		}
+
+		contentLength := int64(-1)
+		if v, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil {
+			contentLength = v
+		}
+
 		respCh <- &FetchResponse{
-			StatusCode: code,
-			Body:       body,
+			StatusCode:    code,
+			Header:        header,
+			Body:          body,
+			URL:           result.Get("url").String(),
+			ContentLength: contentLength,
 		}
 
 		return nil
@@ -91,18 +141,28 @@ func Fetch(ctx context.Context, url string) (*FetchResponse, error) {
 	fetchPromise.Call("then", success, failure)
 	select {
 	case <-ctx.Done():
-		if !ac.IsUndefined() {
-			// Abort the Fetch request.
-			ac.Call("abort")
-		}
-		return nil, ctx.Err()
+		// Abort the Fetch request.
+		return nil, abort(ctx, ac)
 	case resp := <-respCh:
 		return resp, nil
 	case err := <-errCh:
+		if ctx.Err() != nil {
+			// Request has been aborted by the controller
+			return nil, ctx.Err()
+		}
 		return nil, err
 	}
 }
 
+// Aborts the request and returns the context error.
+// Promise callbacks release themselves, so they can safely fire after the reader gives up on them
+func abort(ctx context.Context, ac js.Value) error {
+	if !ac.IsUndefined() {
+		ac.Call("abort")
+	}
+	return ctx.Err()
+}
+
 var errClosed = errors.New("net/http: reader is closed")
 
 // streamReader implements an io.ReadCloser wrapper for ReadableStream.
@@ -110,7 +170,9 @@ var errClosed = errors.New("net/http: reader is closed")
 type streamReader struct {
 	pending []byte
 	stream  js.Value
-	err     error // sticky read error
+	ctx     context.Context
+	ac      js.Value // AbortController or undefined if not supported
+	err     error    // sticky read error
 }
 
 func (r *streamReader) Read(p []byte) (n int, err error) {
@@ -119,10 +181,14 @@ func (r *streamReader) Read(p []byte) (n int, err error) {
 	}
 	if len(r.pending) == 0 {
 		var (
-			bCh   = make(chan []byte, 1)
-			errCh = make(chan error, 1)
+			bCh              = make(chan []byte, 1)
+			errCh            = make(chan error, 1)
+			success, failure js.Func
 		)
-		success := js.FuncOf(func(this js.Value, args []js.Value) any {
+		success = js.FuncOf(func(this js.Value, args []js.Value) any {
+			success.Release()
+			failure.Release()
+
 			result := args[0]
 			if result.Get("done").Bool() {
 				errCh <- io.EOF
@@ -133,8 +199,10 @@ func (r *streamReader) Read(p []byte) (n int, err error) {
 			bCh <- value
 			return nil
 		})
-		defer success.Release()
-		failure := js.FuncOf(func(this js.Value, args []js.Value) any {
+		failure = js.FuncOf(func(this js.Value, args []js.Value) any {
+			success.Release()
+			failure.Release()
+
 			// Assumes it's a TypeError. See
 			// https://developer.mozilla.org/en-US/docs/Web/JavaScript/Reference/Global_Objects/TypeError
 			// for more information on this type. See
@@ -143,14 +211,21 @@ func (r *streamReader) Read(p []byte) (n int, err error) {
 			errCh <- errors.New(args[0].Get("message").String())
 			return nil
 		})
-		defer failure.Release()
 		r.stream.Call("read").Call("then", success, failure)
 		select {
 		case b := <-bCh:
 			r.pending = b
 		case err := <-errCh:
+			if r.ctx.Err() != nil {
+				err = r.ctx.Err()
+			}
 			r.err = err
 			return 0, err
+		case <-r.ctx.Done():
+			r.err = abort(r.ctx, r.ac)
+			// Cancelling the stream resolves the pending read even if AbortController is not supported
+			r.stream.Call("cancel")
+			return 0, r.err
 		}
 	}
 	n = copy(p, r.pending)
@@ -173,6 +248,8 @@ func (r *streamReader) Close() error {
 // https://developer.mozilla.org/en-US/docs/Web/API/Body/arrayBuffer.
 type arrayReader struct {
 	arrayPromise js.Value
+	ctx          context.Context
+	ac           js.Value // AbortController or undefined if not supported
 	pending      []byte
 	read         bool
 	err          error // sticky read error
@@ -185,10 +262,14 @@ func (r *arrayReader) Read(p []byte) (n int, err error) {
 	if !r.read {
 		r.read = true
 		var (
-			bCh   = make(chan []byte, 1)
-			errCh = make(chan error, 1)
+			bCh              = make(chan []byte, 1)
+			errCh            = make(chan error, 1)
+			success, failure js.Func
 		)
-		success := js.FuncOf(func(this js.Value, args []js.Value) any {
+		success = js.FuncOf(func(this js.Value, args []js.Value) any {
+			success.Release()
+			failure.Release()
+
 			// Wrap the input ArrayBuffer with a Uint8Array
 			uint8arrayWrapper := uint8Array.New(args[0])
 			value := make([]byte, uint8arrayWrapper.Get("byteLength").Int())
@@ -196,8 +277,10 @@ func (r *arrayReader) Read(p []byte) (n int, err error) {
 			bCh <- value
 			return nil
 		})
-		defer success.Release()
-		failure := js.FuncOf(func(this js.Value, args []js.Value) any {
+		failure = js.FuncOf(func(this js.Value, args []js.Value) any {
+			success.Release()
+			failure.Release()
+
 			// Assumes it's a TypeError. See
 			// https://developer.mozilla.org/en-US/docs/Web/JavaScript/Reference/Global_Objects/TypeError
 			// for more information on this type.
@@ -205,13 +288,19 @@ func (r *arrayReader) Read(p []byte) (n int, err error) {
 			errCh <- errors.New(args[0].Get("message").String())
 			return nil
 		})
-		defer failure.Release()
 		r.arrayPromise.Call("then", success, failure)
 		select {
 		case b := <-bCh:
 			r.pending = b
 		case err := <-errCh:
+			if r.ctx.Err() != nil {
+				err = r.ctx.Err()
+			}
+			r.err = err
 			return 0, err
+		case <-r.ctx.Done():
+			r.err = abort(r.ctx, r.ac)
+			return 0, r.err
 		}
 	}
 	if len(r.pending) == 0 {
@@ -0,0 +1,18 @@
+package fetch
+
+// Redirect policies
+const (
+	RedirectFollow = "follow" // Follow redirects
+	RedirectError  = "error"  // Fail if the server responds with a redirect
+	// Return the redirect response as is.
+	// Browsers return an opaque response with status code 0 and no headers instead
+	RedirectManual = "manual"
+)
+
+type Request struct {
+	Method   string // Defaults to GET
+	URL      string
+	Header   Header
+	Body     []byte // Optional request body
+	Redirect string // Redirect policy, defaults to RedirectFollow
+}
@@ -1,8 +1,28 @@
 package fetch
 
-import "io"
+import (
+	"io"
+	"strings"
+)
 
 type FetchResponse struct {
-	StatusCode int // e.g. 200
-	Body       io.ReadCloser
+	StatusCode    int // e.g. 200
+	Header        Header
+	Body          io.ReadCloser
+	URL           string // Final URL after following redirects
+	ContentLength int64  // -1 if unknown
+}
+
+// Header contains request or response headers.
+// Keys are stored in lower case since browsers normalize header names this way
+type Header map[string]string
+
+// Returns header value, ignoring key case
+func (h Header) Get(key string) string {
+	return h[strings.ToLower(key)]
+}
+
+// Sets header value, ignoring key case
+func (h Header) Set(key, value string) {
+	h[strings.ToLower(key)] = value
 }
@@ -0,0 +1,23 @@
+package gh
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Writer for progress messages
+var logOutput io.Writer = os.Stdout
+
+// Sets the writer for progress messages. Messages are written to stdout by default
+func SetLogOutput(w io.Writer) {
+	logOutput = w
+}
+
+func logln(a ...any) {
+	fmt.Fprintln(logOutput, a...)
+}
+
+func logf(format string, a ...any) {
+	fmt.Fprintf(logOutput, format, a...)
+}
@@ -0,0 +1,189 @@
+package gh
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/pcm720/nhddl-psu/internal/pathmatch"
+)
+
+// Returned when two files map to the same PSU file
+type CollisionError struct {
+	Name   string // PSU file name
+	First  string
+	Second string
+}
+
+func (e *CollisionError) Error() string {
+	return fmt.Sprintf("%s and %s map to the same PSU file %s", e.First, e.Second, e.Name)
+}
+
+// Returned when some of the file patterns didn't match any archive file
+type MatchError struct {
+	Patterns []string
+}
+
+func (e *MatchError) Error() string {
+	return fmt.Sprintf("no files match %s", strings.Join(e.Patterns, ", "))
+}
+
+// Selects archive files by path patterns.
+// Patterns are slash-separated paths that can contain path.Match wildcards in every element
+// and '**' elements that match any number of directories (e.g. '**/*.elf').
+// A pattern that matches a directory selects every file under it.
+// Patterns starting with '!' exclude matching files.
+// If there are only exclusions, all other files are selected
+type fileMatcher struct {
+	include []string
+	exclude []string
+	matched map[string]bool // Include patterns that matched at least one file
+}
+
+// Parses and validates file patterns
+func newFileMatcher(patterns []string) (*fileMatcher, error) {
+	m := &fileMatcher{matched: map[string]bool{}}
+	for _, p := range patterns {
+		p, excluded := strings.CutPrefix(p, "!")
+		p, err := cleanPattern(p)
+		if err != nil {
+			return nil, err
+		}
+
+		if excluded {
+			m.exclude = append(m.exclude, p)
+		} else {
+			m.include = append(m.include, p)
+		}
+	}
+	return m, nil
+}
+
+// Returns true if the file should be selected
+func (m *fileMatcher) match(name string) bool {
+	name = cleanPath(name)
+	for _, p := range m.exclude {
+		if matchPath(p, name) {
+			return false
+		}
+	}
+	if len(m.include) == 0 {
+		return true
+	}
+
+	selected := false
+	for _, p := range m.include {
+		if matchPath(p, name) {
+			m.matched[p] = true
+			selected = true
+		}
+	}
+	return selected
+}
+
+// Returns MatchError if any of the include patterns didn't match any file
+func (m *fileMatcher) err() error {
+	var unmatched []string
+	for _, p := range m.include {
+		if !m.matched[p] {
+			unmatched = append(unmatched, p)
+		}
+	}
+	if len(unmatched) > 0 {
+		return &MatchError{Patterns: unmatched}
+	}
+	return nil
+}
+
+// Maps selected archive files to PSU file names.
+// Files are named after the last element of their path unless renamed
+type fileRenamer struct {
+	rename  map[string]string // Pattern to PSU file name
+	matched map[string]string // Pattern to the first file it matched
+	names   map[string]string // PSU file name to archive file
+}
+
+// Parses and validates rename patterns and PSU file names
+func newFileRenamer(rename map[string]string) (*fileRenamer, error) {
+	r := &fileRenamer{rename: map[string]string{}, matched: map[string]string{}, names: map[string]string{}}
+	for p, name := range rename {
+		p, err := cleanPattern(p)
+		if err != nil {
+			return nil, err
+		}
+		if (name == "") || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid PSU file name '%s'", name)
+		}
+		r.rename[p] = name
+	}
+	return r, nil
+}
+
+// Returns PSU file name for the archive file.
+// Fails if the rename pattern matches more than one file or the file name is already taken
+func (r *fileRenamer) name(file string) (string, error) {
+	file = cleanPath(file)
+	name := path.Base(file)
+	renamed := false
+	for p, dest := range r.rename {
+		if !matchPath(p, file) {
+			continue
+		}
+		if prev, ok := r.matched[p]; ok {
+			return "", fmt.Errorf("'%s' matches both %s and %s, can't rename them to %s", p, prev, file, dest)
+		}
+		if renamed && (name != dest) {
+			return "", fmt.Errorf("%s is renamed to both %s and %s", file, name, dest)
+		}
+		r.matched[p] = file
+		name = dest
+		renamed = true
+	}
+
+	if prev, ok := r.names[name]; ok {
+		return "", &CollisionError{Name: name, First: prev, Second: file}
+	}
+	r.names[name] = file
+	return name, nil
+}
+
+// Returns MatchError if any of the rename patterns didn't match any selected file
+func (r *fileRenamer) err() error {
+	var unmatched []string
+	for p := range r.rename {
+		if _, ok := r.matched[p]; !ok {
+			unmatched = append(unmatched, p)
+		}
+	}
+	if len(unmatched) > 0 {
+		slices.Sort(unmatched)
+		return &MatchError{Patterns: unmatched}
+	}
+	return nil
+}
+
+// Cleans and validates the pattern
+func cleanPattern(p string) (string, error) {
+	p = cleanPath(p)
+	if p == "" {
+		return "", errors.New("empty file pattern")
+	}
+	for _, elem := range strings.Split(p, "/") {
+		if _, err := path.Match(elem, ""); err != nil {
+			return "", fmt.Errorf("invalid file pattern '%s': %w", p, err)
+		}
+	}
+	return p, nil
+}
+
+// Returns path without leading and trailing slashes
+func cleanPath(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
+
+// Returns true if the pattern matches the file or any of its parent directories
+func matchPath(pattern string, name string) bool {
+	return pathmatch.Match(strings.Split(pattern, "/"), strings.Split(name, "/"), true)
+}
@@ -0,0 +1,91 @@
+//go:build !js
+
+package gh_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/pcm720/nhddl-psu/gh"
+	"github.com/pcm720/nhddl-psu/gh/ghtest"
+)
+
+func TestFileSelection(t *testing.T) {
+	s := ghtest.NewServer()
+	t.Cleanup(s.Close)
+	s.AddRelease("pcm720/nhddl", ghtest.Release{
+		Tag: "v1.0.0",
+		Assets: []ghtest.Asset{{Name: "nhddl.zip", Data: ghtest.ZIP(map[string][]byte{
+			"nhddl.elf":       []byte("nhddl"),
+			"nhddl.map":       []byte("map"),
+			"dir/a.elf":       []byte("a"),
+			"dir/readme.txt":  []byte("readme"),
+			"dir/sub/b.elf":   []byte("b"),
+			"other/a.elf":     []byte("other a"),
+			"other/readme.md": []byte("other readme"),
+		})}},
+	})
+
+	tests := []struct {
+		name      string
+		patterns  []string
+		rename    map[string]string
+		files     []string // Expected PSU file names
+		unmatched []string // Expected MatchError patterns
+		collision string   // Expected CollisionError name
+		fails     bool     // Expected to fail with another error
+	}{
+		{name: "exact", patterns: []string{"nhddl.elf"}, files: []string{"nhddl.elf"}},
+		{name: "glob", patterns: []string{"*.elf"}, files: []string{"nhddl.elf"}},
+		{name: "directory", patterns: []string{"dir"}, files: []string{"a.elf", "b.elf", "readme.txt"}},
+		{name: "recursive glob", patterns: []string{"dir/**/*.elf"}, files: []string{"a.elf", "b.elf"}},
+		{name: "overlapping patterns", patterns: []string{"dir", "dir/*.elf"}, files: []string{"a.elf", "b.elf", "readme.txt"}},
+		{name: "exclude directory", patterns: []string{"dir", "!dir/sub"}, files: []string{"a.elf", "readme.txt"}},
+		{name: "exclude glob", patterns: []string{"dir", "!**/*.txt"}, files: []string{"a.elf", "b.elf"}},
+		{name: "exclusions only", patterns: []string{"!**/*.elf", "!**/readme.*"}, files: []string{"nhddl.map"}},
+		{name: "exclusion wins", patterns: []string{"nhddl.elf", "!nhddl.elf"}, unmatched: []string{"nhddl.elf"}},
+		{name: "no match", patterns: []string{"nhddl.elf", "missing.elf", "dir/*.bin"}, unmatched: []string{"missing.elf", "dir/*.bin"}},
+		{name: "same name", patterns: []string{"**/a.elf"}, collision: "a.elf"},
+		{name: "rename", patterns: []string{"**/a.elf"}, rename: map[string]string{"other/a.elf": "other.elf"}, files: []string{"a.elf", "other.elf"}},
+		{name: "rename unmatched", patterns: []string{"nhddl.elf"}, rename: map[string]string{"missing.elf": "x.elf"}, unmatched: []string{"missing.elf"}},
+		{name: "rename matches several files", patterns: []string{"**/a.elf"}, rename: map[string]string{"**/a.elf": "x.elf"}, fails: true},
+		{name: "invalid pattern", patterns: []string{"dir/["}, fails: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := gh.GetFiles(s.Fetcher("pcm720/nhddl"), "v1.0.0", tt.patterns, gh.FilesOptions{Rename: tt.rename})
+			var matchErr *gh.MatchError
+			var collisionErr *gh.CollisionError
+			switch {
+			case tt.unmatched != nil:
+				if !errors.As(err, &matchErr) {
+					t.Fatalf("expected MatchError, got %v", err)
+				}
+				if !slices.Equal(matchErr.Patterns, tt.unmatched) {
+					t.Errorf("got unmatched patterns %v, expected %v", matchErr.Patterns, tt.unmatched)
+				}
+			case tt.collision != "":
+				if !errors.As(err, &collisionErr) || (collisionErr.Name != tt.collision) {
+					t.Fatalf("expected collision of %s, got %v", tt.collision, err)
+				}
+			case tt.fails:
+				if err == nil {
+					t.Fatal("expected selection to fail")
+				}
+			default:
+				if err != nil {
+					t.Fatal(err)
+				}
+				var names []string
+				for _, f := range files {
+					names = append(names, f.Name)
+				}
+				slices.Sort(names)
+				if !slices.Equal(names, tt.files) {
+					t.Errorf("got files %v, expected %v", names, tt.files)
+				}
+			}
+		})
+	}
+}
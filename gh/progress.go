@@ -0,0 +1,96 @@
+package gh
+
+import (
+	"context"
+	"io"
+)
+
+// Progress phases reported by GetFiles
+const (
+	PhaseResolving   = "resolving"   // Resolving release tag
+	PhaseDownloading = "downloading" // Downloading release asset
+	PhaseVerifying   = "verifying"   // Verifying asset checksums and signature
+	PhaseExtracting  = "extracting"  // Extracting files from the asset archive
+)
+
+// Progress event
+type Progress struct {
+	Phase string
+	Name  string // Release tag, asset or archive file name
+	Read  int64  // Bytes downloaded, only set for PhaseDownloading
+	Total int64  // Asset size, -1 if unknown. Only set for PhaseDownloading
+}
+
+// Returns true if the download is complete
+func (p Progress) Done() bool {
+	return (p.Phase == PhaseDownloading) && (p.Total >= 0) && (p.Read >= p.Total)
+}
+
+// Implemented by sources that report progress
+type progressReporter interface {
+	report(p Progress)
+}
+
+// Reports progress if the source supports it
+func reportProgress(s Source, p Progress) {
+	if r, ok := s.(progressReporter); ok {
+		r.report(p)
+	}
+}
+
+// Calls OnProgress if set
+func (g *Fetcher) report(p Progress) {
+	if g.OnProgress != nil {
+		g.OnProgress(p)
+	}
+}
+
+type assetKey struct{}
+
+// Marks requests made with the context as downloads of the asset.
+// Asset response bodies report download progress and are resumed if interrupted
+func withAsset(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, assetKey{}, name)
+}
+
+// Returns asset name set by withAsset
+func assetName(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(assetKey{}).(string)
+	return name, ok
+}
+
+// Wraps response body to report download progress if the context has been created by withAsset
+func (g *Fetcher) trackProgress(ctx context.Context, resp *Response) {
+	name, ok := assetName(ctx)
+	if !ok || (g.OnProgress == nil) || (resp.StatusCode != 200) {
+		return
+	}
+	r := &progressReader{
+		ReadCloser: resp.Body,
+		progress:   Progress{Phase: PhaseDownloading, Name: name, Total: resp.ContentLength},
+		report:     g.OnProgress,
+	}
+	resp.Body = r
+	g.OnProgress(r.progress)
+}
+
+// progressReader reports the number of bytes read from the response body
+type progressReader struct {
+	io.ReadCloser
+	progress Progress
+	report   func(p Progress)
+}
+
+func (r *progressReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	r.progress.Read += int64(n)
+	switch {
+	case (err == io.EOF) && (r.progress.Total < 0):
+		// Size is known now
+		r.progress.Total = r.progress.Read
+		r.report(r.progress)
+	case n > 0:
+		r.report(r.progress)
+	}
+	return n, err
+}
@@ -0,0 +1,216 @@
+package gh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pcm720/nhddl-psu/gh/internal/fetch"
+)
+
+// Size of the block requested from the server by remoteReader
+const remoteBlockSize = 256 * 1024
+
+// Opens release asset for reading.
+// Uses HTTP Range requests to read only the requested parts of the asset if the server supports them,
+// downloads the whole asset otherwise or if the cache is set.
+// Never passes the token to asset URLs since they might be going through the CORS proxy
+func (g *Fetcher) OpenAsset(asset Asset) (io.ReaderAt, int64, error) {
+	rel := g.CORSProxy + asset.URL
+	logln("opening", rel)
+	ctx := withAsset(context.Background(), asset.Name)
+
+	if g.Cache != nil {
+		// Cache stores whole assets
+		return g.download(ctx, rel)
+	}
+
+	// Request the first byte to check whether the server supports range requests
+	header := fetch.Header{}
+	header.Set("Range", "bytes=0-0")
+	resp, err := g.doGet(ctx, rel, header)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 206:
+		// Content-Range might not be exposed to the browser by CORS policy, use asset size in that case
+		size := contentRangeSize(resp.Header.Get("Content-Range"))
+		if size <= 0 {
+			size = asset.Size
+		}
+		if (size > 0) && (resp.Header.Get("Accept-Ranges") != "none") {
+			logln("server supports range requests, asset size is", size)
+			g.report(Progress{Phase: PhaseDownloading, Name: asset.Name, Total: size})
+			return &remoteReader{fetcher: g, name: asset.Name, url: rel, size: size, blocks: map[int64][]byte{}}, size, nil
+		}
+		resp.Body.Close()
+
+		// Can't determine the asset size, request the whole asset
+		return g.download(ctx, rel)
+	case 200:
+		// Server ignored the Range header and returned the whole asset
+	default:
+		return nil, 0, fmt.Errorf("invalid status code %d", resp.StatusCode)
+	}
+
+	logln("downloading", rel)
+	g.wrapAssetBody(ctx, rel, nil, resp)
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(data), int64(len(data)), nil
+}
+
+// Downloads the whole release asset with a single request.
+// Never passes the token to asset URLs since they might be going through the CORS proxy
+func (g *Fetcher) downloadAsset(asset Asset) (io.ReaderAt, int64, error) {
+	return g.download(withAsset(context.Background(), asset.Name), g.CORSProxy+asset.URL)
+}
+
+// Downloads the whole asset
+func (g *Fetcher) download(ctx context.Context, url string) (io.ReaderAt, int64, error) {
+	logln("downloading", url)
+	resp, err := g.get(ctx, url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, 0, fmt.Errorf("invalid status code %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(data), int64(len(data)), nil
+}
+
+// Wraps response body of the asset download to resume interrupted downloads and report progress.
+// Does nothing if the context has not been created by withAsset
+func (g *Fetcher) wrapAssetBody(ctx context.Context, url string, header Header, resp *Response) {
+	if _, ok := assetName(ctx); !ok {
+		return
+	}
+	g.resumable(ctx, url, header, resp, 0, -1)
+	g.trackProgress(ctx, resp)
+}
+
+// Parses the complete length from the Content-Range header (e.g. 'bytes 0-0/1234').
+// Returns -1 if the length is unknown
+func contentRangeSize(contentRange string) int64 {
+	_, total, ok := strings.Cut(contentRange, "/")
+	if !ok {
+		return -1
+	}
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// remoteReader implements io.ReaderAt over HTTP Range requests.
+// Data is requested and cached in blocks of remoteBlockSize bytes.
+// Safe for parallel use
+type remoteReader struct {
+	fetcher *Fetcher
+	name    string // Asset name for progress reporting
+	url     string
+	size    int64
+
+	mu     sync.Mutex
+	blocks map[int64][]byte // Maps block index to block data
+	read   int64            // Number of bytes downloaded
+}
+
+func (r *remoteReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+
+	// Blocks are requested under the lock, so parallel reads never request the same block twice
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	end := min(off+int64(len(p)), r.size)
+	if err := r.fetchBlocks(off/remoteBlockSize, (end-1)/remoteBlockSize); err != nil {
+		return 0, err
+	}
+
+	for off < end {
+		block := r.blocks[off/remoteBlockSize]
+		c := copy(p[n:n+int(end-off)], block[off%remoteBlockSize:])
+		n += c
+		off += int64(c)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Requests missing blocks in the given range.
+// Every run of consecutive missing blocks is requested with a single request
+func (r *remoteReader) fetchBlocks(first, last int64) error {
+	for i := first; i <= last; i++ {
+		if r.blocks[i] != nil {
+			continue
+		}
+		runEnd := i
+		for (runEnd < last) && (r.blocks[runEnd+1] == nil) {
+			runEnd++
+		}
+		if err := r.fetchRange(i, runEnd); err != nil {
+			return err
+		}
+		i = runEnd
+	}
+	return nil
+}
+
+// Requests blocks from first to last with a single request
+func (r *remoteReader) fetchRange(first, last int64) error {
+	start := first * remoteBlockSize
+	end := min((last+1)*remoteBlockSize, r.size) - 1
+
+	ctx := context.Background()
+	header := fetch.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	resp, err := r.fetcher.doGet(ctx, r.url, header)
+	if err != nil {
+		return err
+	}
+	r.fetcher.resumable(ctx, r.url, nil, resp, start, end)
+	defer resp.Body.Close()
+	if resp.StatusCode != 206 {
+		return fmt.Errorf("invalid status code %d for range request", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) != end-start+1 {
+		return fmt.Errorf("expected %d bytes, got %d", end-start+1, len(data))
+	}
+	for i := first; i <= last; i++ {
+		blockStart := (i - first) * remoteBlockSize
+		r.blocks[i] = data[blockStart:min(blockStart+remoteBlockSize, int64(len(data)))]
+	}
+	r.read += int64(len(data))
+	r.fetcher.report(Progress{Phase: PhaseDownloading, Name: r.name, Read: r.read, Total: r.size})
+	return nil
+}
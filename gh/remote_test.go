@@ -0,0 +1,107 @@
+//go:build !js
+
+package gh_test
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/pcm720/nhddl-psu/gh"
+	"github.com/pcm720/nhddl-psu/gh/ghtest"
+)
+
+// Same as remoteBlockSize
+const blockSize = 256 * 1024
+
+// Starts the server with a single release asset of the given size filled with random data.
+// Returns the server, the asset and its data
+func newAssetServer(t *testing.T, size int) (*ghtest.Server, gh.Asset, []byte) {
+	t.Helper()
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	s := ghtest.NewServer()
+	t.Cleanup(s.Close)
+	s.AddRelease("pcm720/nhddl", ghtest.Release{Tag: "v1.0.0", Assets: []ghtest.Asset{{Name: "asset.bin", Data: data}}})
+	release, err := s.Fetcher("pcm720/nhddl").GetRelease("v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, release.Assets[0], data
+}
+
+func TestRemoteReaderBlocks(t *testing.T) {
+	s, asset, data := newAssetServer(t, 5*blockSize+100)
+	r, size, err := s.Fetcher("pcm720/nhddl").OpenAsset(asset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(data)) {
+		t.Fatalf("got size %d, expected %d", size, len(data))
+	}
+
+	// Returns number of requests made by read
+	requests := func(read func()) int {
+		before := s.Requests()
+		read()
+		return s.Requests() - before
+	}
+	readAt := func(off int64, n int) {
+		t.Helper()
+		buf := make([]byte, n)
+		read, err := r.ReadAt(buf, off)
+		if (err != nil) && (err != io.EOF) {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:read], data[off:off+int64(read)]) {
+			t.Fatalf("data at %d doesn't match", off)
+		}
+	}
+
+	if n := requests(func() { readAt(2*blockSize+10, 10) }); n != 1 {
+		t.Errorf("reading a single block took %d requests", n)
+	}
+	if n := requests(func() { readAt(2*blockSize, 100) }); n != 0 {
+		t.Errorf("reading a cached block took %d requests", n)
+	}
+	// Blocks 0-1 and 3-4 are requested, block 2 is cached
+	if n := requests(func() { readAt(10, 5*blockSize-20) }); n != 2 {
+		t.Errorf("reading around a cached block took %d requests, expected 2", n)
+	}
+	if n := requests(func() { readAt(5*blockSize, 200) }); n != 1 {
+		t.Errorf("reading the last block took %d requests", n)
+	}
+	if n := requests(func() { readAt(0, len(data)) }); n != 0 {
+		t.Errorf("reading cached asset took %d requests", n)
+	}
+}
+
+func TestRemoteReaderParallel(t *testing.T) {
+	s, asset, data := newAssetServer(t, 8*blockSize)
+	r, _, err := s.Fetcher("pcm720/nhddl").OpenAsset(asset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wg := sync.WaitGroup{}
+	for i := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			off := int64(i%8) * blockSize / 2
+			buf := make([]byte, 3*blockSize)
+			n, err := r.ReadAt(buf, off)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(buf[:n], data[off:off+int64(n)]) {
+				t.Errorf("data at %d doesn't match", off)
+			}
+		}()
+	}
+	wg.Wait()
+}
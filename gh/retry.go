@@ -0,0 +1,333 @@
+package gh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// Retry policy for idempotent requests
+type RetryPolicy struct {
+	// Maximum number of attempts, including the first one. Requests are not retried if less than 2
+	MaxAttempts int
+	// Delay before the first retry, doubled for every subsequent retry. Defaults to 500ms
+	BaseDelay time.Duration
+	// Maximum delay between attempts. Defaults to 10s.
+	// Responses with Retry-After longer than this are not retried
+	MaxDelay time.Duration
+	// Timeout of a single attempt, including reading the response body. Defaults to 30s.
+	// Interrupted asset downloads are resumed with a new timeout
+	AttemptTimeout time.Duration
+}
+
+// Returned when the asset has changed on the server while resuming the download
+var errAssetChanged = errors.New("asset changed during download")
+
+// Returns true if the request with the response status code can be retried
+func retryableStatus(code int) bool {
+	switch code {
+	case 408, 429, 500, 502, 503, 504:
+		return true
+	}
+	return false
+}
+
+// Returns delay before the next attempt, or false if there are no attempts left.
+// Uses exponential backoff with jitter, or Retry-After if the response has it
+func (p *RetryPolicy) delay(attempt int, resp *Response) (time.Duration, bool) {
+	if (p == nil) || (attempt >= p.MaxAttempts) {
+		return 0, false
+	}
+	maxDelay := p.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = 10 * time.Second
+	}
+
+	if resp != nil {
+		if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return retryAfter, retryAfter <= maxDelay
+		}
+	}
+
+	d := p.BaseDelay
+	if d <= 0 {
+		d = 500 * time.Millisecond
+	}
+	for i := 1; (i < attempt) && (d < maxDelay); i++ {
+		d *= 2
+	}
+	d = min(d, maxDelay)
+	// Spread retries of concurrent clients
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1)), true
+}
+
+// Returns timeout of a single attempt.
+// Requests are limited by the default timeout even if there's no retry policy
+func (p *RetryPolicy) attemptTimeout() time.Duration {
+	if (p == nil) || (p.AttemptTimeout <= 0) {
+		return 30 * time.Second
+	}
+	return p.AttemptTimeout
+}
+
+// Parses Retry-After header value in seconds or HTTP date format
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if sec, err := strconv.Atoi(v); err == nil {
+		return max(time.Duration(sec)*time.Second, 0), true
+	}
+	// HTTP dates are always in GMT
+	if t, err := time.Parse(time.RFC1123, v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+// Waits for the duration or until the context is done
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Performs request, retrying GET and HEAD requests on network errors and retryable status codes.
+// Every attempt has its own timeout, so ctx only needs to limit the total time
+func (g *Fetcher) doRetry(ctx context.Context, req *Request) (*Response, error) {
+	if (req.Method != "") && (req.Method != "GET") && (req.Method != "HEAD") {
+		return g.attempt(ctx, req)
+	}
+	for attempt := 1; ; attempt++ {
+		resp, err := g.attempt(ctx, req)
+		if ctx.Err() != nil {
+			if err == nil {
+				resp.Body.Close()
+			}
+			return nil, ctx.Err()
+		}
+		if (err == nil) && !retryableStatus(resp.StatusCode) {
+			return resp, nil
+		}
+
+		d, ok := g.Retry.delay(attempt, resp)
+		if !ok {
+			return resp, err
+		}
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = fmt.Sprintf("status code %d", resp.StatusCode)
+			resp.Body.Close()
+		}
+		logf("retrying %s in %s: %s\n", req.URL, d.Round(time.Millisecond), reason)
+		if err := sleep(ctx, d); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Performs a single attempt with the attempt timeout.
+// The timeout also applies to reading the response body and is released when the body is closed
+func (g *Fetcher) attempt(ctx context.Context, req *Request) (*Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, g.Retry.attemptTimeout())
+	resp, err := g.do(ctx, req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelingBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelingBody cancels the attempt context when the body is closed
+type cancelingBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// resumingReader resumes interrupted response body reads with Range requests
+type resumingReader struct {
+	ctx     context.Context
+	fetcher *Fetcher
+	url     string
+	header  Header // Request headers without conditional and Range headers
+	body    io.ReadCloser
+	start   int64 // Offset of the first byte of the body in the asset
+	end     int64 // Offset of the last byte of the body in the asset, -1 for the end of the asset
+	read    int64 // Number of bytes read
+	total   int64 // Expected body size, -1 if unknown
+	ifRange string
+	attempt int
+}
+
+// Wraps successful asset response body into resumingReader if the fetcher has the retry policy.
+// start and end set the requested byte range (-1 for the end of the asset)
+func (g *Fetcher) resumable(ctx context.Context, url string, header Header, resp *Response, start, end int64) {
+	if (g.Retry == nil) || (g.Retry.MaxAttempts < 2) || ((resp.StatusCode != 200) && (resp.StatusCode != 206)) {
+		return
+	}
+	h := Header{}
+	for k, v := range header {
+		switch k {
+		case "range", "if-none-match", "if-modified-since", "if-range":
+		default:
+			h[k] = v
+		}
+	}
+	r := &resumingReader{
+		ctx:     ctx,
+		fetcher: g,
+		url:     url,
+		header:  h,
+		body:    resp.Body,
+		start:   start,
+		end:     end,
+		total:   resp.ContentLength,
+		attempt: 1,
+	}
+	// Only resume if the asset hasn't changed since the first response
+	r.ifRange = resp.Header.Get("ETag")
+	if r.ifRange == "" {
+		r.ifRange = resp.Header.Get("Last-Modified")
+	}
+	resp.Body = r
+}
+
+func (r *resumingReader) Read(p []byte) (n int, err error) {
+	for {
+		n, err = r.body.Read(p)
+		r.read += int64(n)
+		if (err == io.EOF) && (r.total >= 0) && (r.read < r.total) {
+			err = io.ErrUnexpectedEOF
+		}
+		if (err == nil) || (err == io.EOF) || (r.ctx.Err() != nil) {
+			return n, err
+		}
+		if n > 0 {
+			// Report the data and resume on the next read.
+			// Only attempts that didn't get any data count towards the limit
+			r.attempt = 1
+			return n, nil
+		}
+
+		d, ok := r.fetcher.Retry.delay(r.attempt, nil)
+		if !ok {
+			return 0, err
+		}
+		r.attempt++
+		logf("resuming %s at %d bytes in %s: %s\n", r.url, r.start+r.read, d.Round(time.Millisecond), err)
+		if err := sleep(r.ctx, d); err != nil {
+			return 0, err
+		}
+		if err := r.resume(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Requests the rest of the body.
+// Network errors and retryable status codes leave the body failing with the cause,
+// so the request is retried on the next read and the cause is returned once there are no attempts left
+func (r *resumingReader) resume() error {
+	r.body.Close()
+	r.body = io.NopCloser(errorReader{io.ErrUnexpectedEOF})
+
+	header := Header{}
+	for k, v := range r.header {
+		header[k] = v
+	}
+	offset := r.start + r.read
+	rng := fmt.Sprintf("bytes=%d-", offset)
+	if r.end >= 0 {
+		rng += strconv.FormatInt(r.end, 10)
+	}
+	header.Set("Range", rng)
+	if r.ifRange != "" {
+		header.Set("If-Range", r.ifRange)
+	}
+
+	// Every resumed request gets its own timeout
+	resp, err := r.fetcher.attempt(r.ctx, &Request{URL: r.url, Header: header})
+	if err != nil {
+		r.body = resumeError(offset, err)
+		return nil
+	}
+	switch {
+	case resp.StatusCode == 206:
+		if start := contentRangeStart(resp.Header.Get("Content-Range")); (start >= 0) && (start != offset) {
+			resp.Body.Close()
+			return fmt.Errorf("server resumed download at %d instead of %d", start, offset)
+		}
+		r.body = resp.Body
+	case resp.StatusCode == 200:
+		// Server doesn't support range requests or the asset has changed
+		if (r.ifRange != "") && (resp.Header.Get("ETag") != r.ifRange) && (resp.Header.Get("Last-Modified") != r.ifRange) {
+			resp.Body.Close()
+			return errAssetChanged
+		}
+		// Skip data that has already been read
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			r.body = resumeError(offset, err)
+			return nil
+		}
+		r.body = resp.Body
+		if r.end >= 0 {
+			r.body = struct {
+				io.Reader
+				io.Closer
+			}{io.LimitReader(resp.Body, r.end-offset+1), resp.Body}
+		}
+	case retryableStatus(resp.StatusCode):
+		resp.Body.Close()
+		r.body = resumeError(offset, fmt.Errorf("status code %d", resp.StatusCode))
+	default:
+		resp.Body.Close()
+		return fmt.Errorf("invalid status code %d while resuming download", resp.StatusCode)
+	}
+	return nil
+}
+
+func (r *resumingReader) Close() error {
+	return r.body.Close()
+}
+
+// Returns body that fails with the error of the resumed request
+func resumeError(offset int64, err error) io.ReadCloser {
+	return io.NopCloser(errorReader{fmt.Errorf("failed to resume download at %d bytes: %w", offset, err)})
+}
+
+type errorReader struct {
+	err error
+}
+
+func (r errorReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+// Parses the first byte position from the Content-Range header (e.g. 'bytes 100-199/1234').
+// Returns -1 if the header is missing or invalid
+func contentRangeStart(contentRange string) int64 {
+	var start int64
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-", &start); err != nil {
+		return -1
+	}
+	return start
+}
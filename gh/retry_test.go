@@ -0,0 +1,216 @@
+//go:build !js
+
+package gh_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pcm720/nhddl-psu/gh"
+	"github.com/pcm720/nhddl-psu/gh/ghtest"
+)
+
+// Returns retry policy with short delays and the given attempt timeout
+func testPolicy(attempts int, timeout time.Duration) *gh.RetryPolicy {
+	return &gh.RetryPolicy{MaxAttempts: attempts, BaseDelay: time.Millisecond, AttemptTimeout: timeout}
+}
+
+// Reads the whole asset with range requests and compares it with data
+func readAsset(t *testing.T, f *gh.Fetcher, asset gh.Asset, data []byte) {
+	t.Helper()
+	r, size, err := f.OpenAsset(asset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, size)
+	if _, err := r.ReadAt(buf, 0); (err != nil) && (err != io.EOF) {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Fatal("asset data doesn't match")
+	}
+}
+
+func TestRetryStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		failures []int
+		requests int
+		ok       bool
+	}{
+		{"recovers", 3, []int{502, 503}, 3, true},
+		{"gives up", 2, []int{502, 503, 504}, 2, false},
+		{"not retryable", 3, []int{404}, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newReleaseServer(t, ghtest.GitHub)
+			f := s.Fetcher("pcm720/nhddl")
+			f.Retry = testPolicy(tt.attempts, time.Second)
+			s.Fail(tt.failures...)
+
+			_, err := f.GetRelease("v1.0.0")
+			if tt.ok && (err != nil) {
+				t.Fatal(err)
+			}
+			if !tt.ok && (err == nil) {
+				t.Fatal("expected request to fail")
+			}
+			if requests := s.Requests(); requests != tt.requests {
+				t.Errorf("got %d requests, expected %d", requests, tt.requests)
+			}
+		})
+	}
+}
+
+func TestRetryHungAttempt(t *testing.T) {
+	s := newReleaseServer(t, ghtest.GitHub)
+	f := s.Fetcher("pcm720/nhddl")
+	f.Retry = testPolicy(2, 50*time.Millisecond)
+	transport := f.Transport
+	var hung atomic.Bool
+	f.Transport = gh.DoerFunc(func(ctx context.Context, req *gh.Request) (*gh.Response, error) {
+		if !hung.Swap(true) {
+			// Hang until the attempt times out
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return transport.Do(ctx, req)
+	})
+
+	if _, err := f.GetRelease("v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetryAfterExceedsAttemptTimeout(t *testing.T) {
+	s := newReleaseServer(t, ghtest.GitHub)
+	f := s.Fetcher("pcm720/nhddl")
+	f.Retry = testPolicy(2, 100*time.Millisecond)
+	transport := f.Transport
+	var limited atomic.Bool
+	f.Transport = gh.DoerFunc(func(ctx context.Context, req *gh.Request) (*gh.Response, error) {
+		if !limited.Swap(true) {
+			return &gh.Response{
+				StatusCode: 503,
+				Header:     gh.Header{"retry-after": "1"},
+				Body:       io.NopCloser(strings.NewReader("")),
+				URL:        req.URL,
+			}, nil
+		}
+		return transport.Do(ctx, req)
+	})
+
+	start := time.Now()
+	if _, err := f.GetRelease("v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, expected to wait for Retry-After", elapsed)
+	}
+}
+
+func TestResumeTruncatedDownload(t *testing.T) {
+	s := newReleaseServer(t, ghtest.GitHub)
+	f := s.Fetcher("pcm720/nhddl")
+	f.Retry = testPolicy(3, time.Second)
+	s.Truncate(1)
+
+	files, err := gh.GetFiles(f, "v1.0.0", []string{"nhddl.elf"}, gh.FilesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(files) != 1) || !bytes.Equal(files[0].Data, []byte("v1.0.0")) {
+		t.Fatalf("unexpected files %v", files)
+	}
+}
+
+func TestResumeTruncatedRange(t *testing.T) {
+	s, asset, data := newAssetServer(t, 3*blockSize)
+	f := s.Fetcher("pcm720/nhddl")
+	f.Retry = testPolicy(3, time.Second)
+	// Truncate the first block request, but not the request that checks range support
+	transport := f.Transport
+	var truncated atomic.Bool
+	f.Transport = gh.DoerFunc(func(ctx context.Context, req *gh.Request) (*gh.Response, error) {
+		if (req.Header.Get("Range") != "bytes=0-0") && !truncated.Swap(true) {
+			s.Truncate(1)
+		}
+		return transport.Do(ctx, req)
+	})
+
+	readAsset(t, f, asset, data)
+}
+
+func TestResumeStalledBody(t *testing.T) {
+	s, asset, data := newAssetServer(t, 2*blockSize)
+	f := s.Fetcher("pcm720/nhddl")
+	f.Retry = testPolicy(3, 100*time.Millisecond)
+	transport := f.Transport
+	var stalled, resumed atomic.Bool
+	f.Transport = gh.DoerFunc(func(ctx context.Context, req *gh.Request) (*gh.Response, error) {
+		resp, err := transport.Do(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case req.Header.Get("Range") == "bytes=0-0":
+		case !stalled.Swap(true):
+			// Send part of the body, then stall until the attempt times out
+			resp.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(io.LimitReader(resp.Body, 1000), stallReader{ctx}), resp.Body}
+		default:
+			resumed.Store(true)
+		}
+		return resp, nil
+	})
+
+	readAsset(t, f, asset, data)
+	if !resumed.Load() {
+		t.Error("expected stalled download to be resumed")
+	}
+}
+
+// stallReader blocks until the context is done
+type stallReader struct {
+	ctx context.Context
+}
+
+func (r stallReader) Read([]byte) (int, error) {
+	<-r.ctx.Done()
+	return 0, r.ctx.Err()
+}
+
+func TestResumeErrorCause(t *testing.T) {
+	s, asset, _ := newAssetServer(t, 2*blockSize)
+	f := s.Fetcher("pcm720/nhddl")
+	f.Retry = testPolicy(2, time.Second)
+	transport := f.Transport
+	f.Transport = gh.DoerFunc(func(ctx context.Context, req *gh.Request) (*gh.Response, error) {
+		if req.Header.Get("If-Range") != "" {
+			return nil, errors.New("connection refused")
+		}
+		if req.Header.Get("Range") != "bytes=0-0" {
+			s.Truncate(1)
+		}
+		return transport.Do(ctx, req)
+	})
+
+	r, size, err := f.OpenAsset(asset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.ReadAt(make([]byte, size), 0)
+	if (err == nil) || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("expected resume error cause, got %v", err)
+	}
+}
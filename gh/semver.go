@@ -0,0 +1,263 @@
+package gh
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Semantic version.
+// See https://semver.org
+type Version struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string // Dot-separated prerelease identifiers without the leading hyphen
+}
+
+// Parses semantic version, ignoring build metadata.
+// Accepts optional 'v' prefix and missing minor and patch versions (e.g. v1.2)
+func ParseVersion(s string) (Version, error) {
+	v := Version{}
+	str := strings.TrimPrefix(s, "v")
+	if i := strings.IndexByte(str, '+'); i >= 0 {
+		str = str[:i]
+	}
+	if i := strings.IndexByte(str, '-'); i >= 0 {
+		v.Prerelease = str[i+1:]
+		str = str[:i]
+		if v.Prerelease == "" {
+			return Version{}, fmt.Errorf("invalid version '%s'", s)
+		}
+	}
+
+	parts := strings.Split(str, ".")
+	if len(parts) > 3 {
+		return Version{}, fmt.Errorf("invalid version '%s'", s)
+	}
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if (err != nil) || (n < 0) {
+			return Version{}, fmt.Errorf("invalid version '%s'", s)
+		}
+		*nums[i] = n
+	}
+	return v, nil
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	return s
+}
+
+// Returns -1 if v is lower than o, 0 if versions are equal and 1 if v is greater than o
+func (v Version) Compare(o Version) int {
+	if c := compareInt(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+
+	// Version without prerelease has higher precedence
+	switch {
+	case v.Prerelease == o.Prerelease:
+		return 0
+	case v.Prerelease == "":
+		return 1
+	case o.Prerelease == "":
+		return -1
+	}
+
+	vIDs := strings.Split(v.Prerelease, ".")
+	oIDs := strings.Split(o.Prerelease, ".")
+	for i := 0; (i < len(vIDs)) && (i < len(oIDs)); i++ {
+		if c := compareIdentifier(vIDs[i], oIDs[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInt(len(vIDs), len(oIDs))
+}
+
+// Compares prerelease identifiers.
+// Numeric identifiers are compared numerically and always have lower precedence than alphanumeric ones
+func compareIdentifier(a, b string) int {
+	aNum, aErr := strconv.Atoi(a)
+	bNum, bErr := strconv.Atoi(b)
+	switch {
+	case (aErr == nil) && (bErr == nil):
+		return compareInt(aNum, bNum)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Version constraint, e.g. '>=1.2.0 <2' or '^1.1 || ~2.0.1'.
+// Space-separated comparators are ANDed, groups separated by || are ORed
+type Constraint struct {
+	groups          [][]comparator
+	allowPrerelease bool
+}
+
+type comparator struct {
+	op string
+	v  Version
+}
+
+// Returns true if tag is a version constraint and not a plain tag
+func IsConstraint(s string) bool {
+	return strings.ContainsAny(s, "<>=~^!")
+}
+
+// Parses version constraint.
+// Supported operators are =, !=, >, >=, <, <=, ~ (patch updates) and ^ (minor and patch updates).
+// Operators can be separated from the version by a space (e.g. '>= 1.2.0')
+func ParseConstraint(s string) (Constraint, error) {
+	c := Constraint{}
+	for _, group := range strings.Split(s, "||") {
+		var comparators []comparator
+		fields := strings.Fields(group)
+		for i := 0; i < len(fields); i++ {
+			f := fields[i]
+			if (strings.TrimLeft(f, "<>=!~^") == "") && (i+1 < len(fields)) {
+				// Bare operator applies to the next field
+				i++
+				f += fields[i]
+			}
+			ver := strings.TrimLeft(f, "<>=!~^")
+			op := f[:len(f)-len(ver)]
+			v, err := ParseVersion(ver)
+			if err != nil {
+				return Constraint{}, fmt.Errorf("invalid constraint '%s': %w", s, err)
+			}
+			if v.Prerelease != "" {
+				c.allowPrerelease = true
+			}
+
+			switch op {
+			case "", "=", "==":
+				comparators = append(comparators, comparator{"=", v})
+			case "!=", ">", ">=", "<", "<=":
+				comparators = append(comparators, comparator{op, v})
+			case "~":
+				// ~1.2.3 := >=1.2.3 <1.3.0, ~1 := >=1.0.0 <2.0.0
+				upper := Version{Major: v.Major, Minor: v.Minor + 1, Prerelease: "0"}
+				if versionParts(ver) == 1 {
+					upper = Version{Major: v.Major + 1, Prerelease: "0"}
+				}
+				comparators = append(comparators, comparator{">=", v}, comparator{"<", upper})
+			case "^":
+				// ^1.2.3 := >=1.2.3 <2.0.0, ^0.2.3 := >=0.2.3 <0.3.0, ^0.0.3 := >=0.0.3 <0.0.4.
+				// Missing components are not fixed: ^0 := >=0.0.0 <1.0.0, ^0.0 := >=0.0.0 <0.1.0
+				parts := versionParts(ver)
+				upper := Version{Major: v.Major + 1, Prerelease: "0"}
+				switch {
+				case (v.Major == 0) && (parts > 1) && ((v.Minor != 0) || (parts == 2)):
+					upper = Version{Minor: v.Minor + 1, Prerelease: "0"}
+				case (v.Major == 0) && (parts == 3):
+					upper = Version{Patch: v.Patch + 1, Prerelease: "0"}
+				}
+				comparators = append(comparators, comparator{">=", v}, comparator{"<", upper})
+			default:
+				return Constraint{}, fmt.Errorf("invalid constraint '%s': unknown operator '%s'", s, op)
+			}
+		}
+		if len(comparators) == 0 {
+			return Constraint{}, fmt.Errorf("invalid constraint '%s'", s)
+		}
+		c.groups = append(c.groups, comparators)
+	}
+	return c, nil
+}
+
+// Returns number of components set in the version (e.g. 2 for v1.2)
+func versionParts(s string) int {
+	s, _, _ = strings.Cut(strings.TrimPrefix(s, "v"), "+")
+	s, _, _ = strings.Cut(s, "-")
+	return strings.Count(s, ".") + 1
+}
+
+// Returns true if version satisfies the constraint.
+// Prerelease versions are matched only if the constraint includes a prerelease version
+func (c Constraint) Check(v Version) bool {
+	if (v.Prerelease != "") && !c.allowPrerelease {
+		return false
+	}
+
+	for _, group := range c.groups {
+		ok := true
+		for _, cmp := range group {
+			if !cmp.check(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c comparator) check(v Version) bool {
+	res := v.Compare(c.v)
+	switch c.op {
+	case "=":
+		return res == 0
+	case "!=":
+		return res != 0
+	case ">":
+		return res > 0
+	case ">=":
+		return res >= 0
+	case "<":
+		return res < 0
+	case "<=":
+		return res <= 0
+	}
+	return false
+}
+
+// Returns the tag with the highest version satisfying the constraint.
+// Tags that are not valid versions are ignored
+func MaxVersionTag(tags []string, c Constraint) (string, error) {
+	var (
+		maxTag     string
+		maxVersion Version
+	)
+	for _, t := range tags {
+		v, err := ParseVersion(t)
+		if err != nil || !c.Check(v) {
+			continue
+		}
+		if (maxTag == "") || (v.Compare(maxVersion) > 0) {
+			maxTag = t
+			maxVersion = v
+		}
+	}
+	if maxTag == "" {
+		return "", errors.New("no tags satisfy the constraint")
+	}
+	return maxTag, nil
+}
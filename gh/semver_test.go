@@ -0,0 +1,180 @@
+package gh_test
+
+import (
+	"testing"
+
+	"github.com/pcm720/nhddl-psu/gh"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected gh.Version
+		valid    bool
+	}{
+		{"1.2.3", gh.Version{Major: 1, Minor: 2, Patch: 3}, true},
+		{"v1.2.3", gh.Version{Major: 1, Minor: 2, Patch: 3}, true},
+		{"v1.2", gh.Version{Major: 1, Minor: 2}, true},
+		{"1", gh.Version{Major: 1}, true},
+		{"1.2.3-rc.1", gh.Version{Major: 1, Minor: 2, Patch: 3, Prerelease: "rc.1"}, true},
+		{"1.2.3-rc.1+build.5", gh.Version{Major: 1, Minor: 2, Patch: 3, Prerelease: "rc.1"}, true},
+		{"1.2.3+build", gh.Version{Major: 1, Minor: 2, Patch: 3}, true},
+		{"", gh.Version{}, false},
+		{"nightly", gh.Version{}, false},
+		{"1.2.3.4", gh.Version{}, false},
+		{"1.2.3-", gh.Version{}, false},
+		{"1.-2.3", gh.Version{}, false},
+		{"1..3", gh.Version{}, false},
+	}
+	for _, tt := range tests {
+		v, err := gh.ParseVersion(tt.s)
+		if tt.valid != (err == nil) {
+			t.Errorf("ParseVersion(%q): unexpected error %v", tt.s, err)
+			continue
+		}
+		if v != tt.expected {
+			t.Errorf("ParseVersion(%q) = %+v, expected %+v", tt.s, v, tt.expected)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	// Versions in ascending order, see https://semver.org/#spec-item-11
+	ordered := []string{
+		"0.9.9",
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.9.0",
+		"1.10.0",
+		"1.11.0",
+		"2.0.0",
+	}
+	for i, a := range ordered {
+		for j, b := range ordered {
+			va, _ := gh.ParseVersion(a)
+			vb, _ := gh.ParseVersion(b)
+			expected := 0
+			switch {
+			case i < j:
+				expected = -1
+			case i > j:
+				expected = 1
+			}
+			if c := va.Compare(vb); c != expected {
+				t.Errorf("%s.Compare(%s) = %d, expected %d", a, b, c, expected)
+			}
+		}
+	}
+
+	// Prefix and build metadata don't affect precedence
+	for _, pair := range [][2]string{{"v1.2.3", "1.2.3"}, {"1.2.3+a", "1.2.3+b"}, {"1.2", "1.2.0"}} {
+		va, _ := gh.ParseVersion(pair[0])
+		vb, _ := gh.ParseVersion(pair[1])
+		if c := va.Compare(vb); c != 0 {
+			t.Errorf("%s.Compare(%s) = %d, expected 0", pair[0], pair[1], c)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		expected   bool
+	}{
+		{"1.2.3", "1.2.3", true},
+		{"=1.2.3", "1.2.4", false},
+		{"!=1.2.3", "1.2.4", true},
+		{">1.2.3", "1.2.3", false},
+		{">=1.2.3", "1.2.3", true},
+		{"<1.2.3", "1.2.2", true},
+		{"<=1.2.3", "1.2.4", false},
+		{">= 1.2.0", "1.2.0", true},
+		{">= 1.2.0", "1.1.9", false},
+		{">= 1.2.0 < 2", "1.9.9", true},
+		{">= 1.2.0 < 2", "2.0.0", false},
+		{">=1.2.0 <2", "1.5.0", true},
+		{">=1.2.0 <2", "2.0.0", false},
+		{"<1 || >=2", "1.5.0", false},
+		{"<1 || >=2", "2.1.0", true},
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.3.0", false},
+		{"~1.2", "1.2.0", true},
+		{"~1.2", "1.3.0", false},
+		{"~1", "1.9.0", true},
+		{"~1", "2.0.0", false},
+		{"~ 1", "1.9.0", true},
+		{"^1.2.3", "1.9.0", true},
+		{"^1.2.3", "1.2.2", false},
+		{"^1.2.3", "2.0.0", false},
+		{"^0.2.3", "0.2.9", true},
+		{"^0.2.3", "0.3.0", false},
+		{"^0.0.3", "0.0.3", true},
+		{"^0.0.3", "0.0.4", false},
+		{"^0.0", "0.0.9", true},
+		{"^0.0", "0.1.0", false},
+		{"^0", "0.9.0", true},
+		{"^0", "1.0.0", false},
+		// Prereleases are only matched if the constraint includes one
+		{">=1.0.0", "2.0.0-rc.1", false},
+		{"^1.2.3", "2.0.0-rc.1", false},
+		{">=2.0.0-rc.1", "2.0.0-rc.2", true},
+		{">=2.0.0-rc.1", "2.0.0", true},
+	}
+	for _, tt := range tests {
+		c, err := gh.ParseConstraint(tt.constraint)
+		if err != nil {
+			t.Errorf("ParseConstraint(%q): %v", tt.constraint, err)
+			continue
+		}
+		v, err := gh.ParseVersion(tt.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Check(v) != tt.expected {
+			t.Errorf("%q.Check(%s) = %t, expected %t", tt.constraint, tt.version, !tt.expected, tt.expected)
+		}
+	}
+}
+
+func TestParseConstraintErrors(t *testing.T) {
+	for _, s := range []string{"", ">=", ">= ", "1.2.3 ||", ">=nightly", "=>1.2.3", "<>1"} {
+		if _, err := gh.ParseConstraint(s); err == nil {
+			t.Errorf("ParseConstraint(%q): expected error", s)
+		}
+	}
+}
+
+func TestMaxVersionTag(t *testing.T) {
+	tags := []string{"nightly", "v1.0.0", "v1.10.0", "v1.9.0", "v2.0.0-rc.1", "v1.10.1-beta"}
+	tests := []struct {
+		constraint string
+		expected   string
+	}{
+		{">=0.0.0", "v1.10.0"},
+		{"<1.10", "v1.9.0"},
+		{"~1.0", "v1.0.0"},
+		{">=2.0.0-rc.0", "v2.0.0-rc.1"},
+		{">=3", ""},
+	}
+	for _, tt := range tests {
+		c, err := gh.ParseConstraint(tt.constraint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tag, err := gh.MaxVersionTag(tags, c)
+		if (tt.expected == "") != (err != nil) {
+			t.Errorf("MaxVersionTag(%q): unexpected error %v", tt.constraint, err)
+		}
+		if tag != tt.expected {
+			t.Errorf("MaxVersionTag(%q) = %q, expected %q", tt.constraint, tag, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package gh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Public key used to verify detached asset signatures.
+// Supports minisign keys and raw Ed25519 keys
+type PublicKey struct {
+	KeyID []byte // minisign key ID, nil for raw Ed25519 keys
+	Key   ed25519.PublicKey
+}
+
+// minisign signature algorithms.
+// See https://jedisct1.github.io/minisign/
+const (
+	minisignAlgPure   = "Ed" // Signature of the message itself
+	minisignAlgHashed = "ED" // Signature of the BLAKE2b-512 message hash
+)
+
+// Returned when the asset signature is missing or invalid
+type SignatureError struct {
+	Asset  string
+	Reason string
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("signature verification failed for %s: %s", e.Asset, e.Reason)
+}
+
+// Parses public key.
+// Accepts minisign public key files, base64-encoded minisign keys,
+// and raw or base64-encoded Ed25519 keys
+func ParsePublicKey(data []byte) (*PublicKey, error) {
+	if len(data) == ed25519.PublicKeySize {
+		return &PublicKey{Key: ed25519.PublicKey(data)}, nil
+	}
+
+	// Skip minisign comment line
+	var encoded string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if (line != "") && !strings.HasPrefix(line, "untrusted comment:") {
+			encoded = line
+			break
+		}
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	switch len(raw) {
+	case ed25519.PublicKeySize:
+		return &PublicKey{Key: ed25519.PublicKey(raw)}, nil
+	case 2 + 8 + ed25519.PublicKeySize:
+		if string(raw[:2]) != minisignAlgPure {
+			return nil, errors.New("invalid public key: unsupported minisign algorithm")
+		}
+		return &PublicKey{KeyID: raw[2:10], Key: ed25519.PublicKey(raw[10:])}, nil
+	}
+	return nil, errors.New("invalid public key length")
+}
+
+// Verifies the detached signature of the message.
+// Minisign signatures are verified along with the trusted comment,
+// raw signatures are expected to be 64 bytes long or base64-encoded
+func (k *PublicKey) Verify(message []byte, sig []byte) error {
+	if bytes.HasPrefix(sig, []byte("untrusted comment:")) {
+		return k.verifyMinisign(message, sig)
+	}
+
+	if len(sig) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+		if err != nil {
+			return errors.New("invalid signature encoding")
+		}
+		sig = decoded
+	}
+	if len(sig) != ed25519.SignatureSize {
+		return errors.New("invalid signature length")
+	}
+	if !ed25519.Verify(k.Key, message, sig) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// Verifies minisign signature.
+// The signature file consists of the untrusted comment, the signature,
+// the trusted comment and the global signature of the signature and trusted comment
+func (k *PublicKey) verifyMinisign(message []byte, sigFile []byte) error {
+	lines := strings.Split(strings.ReplaceAll(string(sigFile), "\r\n", "\n"), "\n")
+	if len(lines) < 4 {
+		return errors.New("invalid minisign signature file")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if (err != nil) || (len(sig) != 2+8+ed25519.SignatureSize) {
+		return errors.New("invalid minisign signature")
+	}
+	if (k.KeyID != nil) && !bytes.Equal(sig[2:10], k.KeyID) {
+		return errors.New("signature was made with a different key")
+	}
+
+	switch string(sig[:2]) {
+	case minisignAlgPure:
+	case minisignAlgHashed:
+		h := blake2b.Sum512(message)
+		message = h[:]
+	default:
+		return errors.New("unsupported minisign signature algorithm")
+	}
+	if !ed25519.Verify(k.Key, message, sig[10:]) {
+		return errors.New("invalid signature")
+	}
+
+	trustedComment, ok := strings.CutPrefix(lines[2], "trusted comment: ")
+	if !ok {
+		return errors.New("missing trusted comment")
+	}
+	globalSig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if (err != nil) || (len(globalSig) != ed25519.SignatureSize) {
+		return errors.New("invalid global signature")
+	}
+	if !ed25519.Verify(k.Key, append(bytes.Clone(sig[10:]), trustedComment...), globalSig) {
+		return errors.New("invalid trusted comment signature")
+	}
+	return nil
+}
+
+// Finds signature asset for the asset (<asset name>.minisig or <asset name>.sig)
+// and verifies the asset against it
+func verifySignature(s Source, release *Release, asset Asset, r io.ReaderAt, size int64, key *PublicKey) error {
+	var sigAsset *Asset
+	for _, a := range release.Assets {
+		if (a.Name == asset.Name+".minisig") || (a.Name == asset.Name+".sig") {
+			sigAsset = &a
+			break
+		}
+	}
+	if sigAsset == nil {
+		return &SignatureError{Asset: asset.Name, Reason: "asset is not signed"}
+	}
+
+	logln("getting signature from", sigAsset.Name)
+	sr, sigSize, err := DownloadAsset(s, *sigAsset)
+	if err != nil {
+		return err
+	}
+	sig, err := io.ReadAll(io.NewSectionReader(sr, 0, sigSize))
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return err
+	}
+
+	if err := key.Verify(data, sig); err != nil {
+		return &SignatureError{Asset: asset.Name, Reason: err.Error()}
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package gh_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/pcm720/nhddl-psu/gh"
+	"golang.org/x/crypto/blake2b"
+)
+
+// minisign key pair generated for the test
+type minisignKey struct {
+	id   []byte
+	pub  ed25519.PublicKey
+	priv ed25519.PrivateKey
+}
+
+func newMinisignKey(t *testing.T, id string) minisignKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return minisignKey{id: []byte(id), pub: pub, priv: priv}
+}
+
+// Returns minisign public key file
+func (k minisignKey) publicKeyFile() []byte {
+	raw := append(append([]byte("Ed"), k.id...), k.pub...)
+	return []byte("untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(raw) + "\n")
+}
+
+// Returns minisign signature file with the given algorithm (Ed or ED) and trusted comment
+func (k minisignKey) sign(alg string, message []byte, comment string) string {
+	if alg == "ED" {
+		h := blake2b.Sum512(message)
+		message = h[:]
+	}
+	sig := ed25519.Sign(k.priv, message)
+	global := ed25519.Sign(k.priv, append(bytes.Clone(sig), comment...))
+	return "untrusted comment: signature\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte(alg), k.id...), sig...)) + "\n" +
+		"trusted comment: " + comment + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n"
+}
+
+func TestParsePublicKey(t *testing.T) {
+	k := newMinisignKey(t, "12345678")
+	hashedKey := append(append([]byte("ED"), k.id...), k.pub...)
+	tests := []struct {
+		name  string
+		data  []byte
+		keyID []byte // nil for raw Ed25519 keys
+		ok    bool
+	}{
+		{"raw", k.pub, nil, true},
+		{"base64", []byte(base64.StdEncoding.EncodeToString(k.pub)), nil, true},
+		{"minisign file", k.publicKeyFile(), k.id, true},
+		{"minisign line", bytes.Split(k.publicKeyFile(), []byte("\n"))[1], k.id, true},
+		{"CRLF minisign file", bytes.ReplaceAll(k.publicKeyFile(), []byte("\n"), []byte("\r\n")), k.id, true},
+		{"invalid base64", []byte("not a key!"), nil, false},
+		{"wrong length", []byte(base64.StdEncoding.EncodeToString(k.pub[:16])), nil, false},
+		{"unsupported algorithm", []byte(base64.StdEncoding.EncodeToString(hashedKey)), nil, false},
+		{"empty", nil, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := gh.ParsePublicKey(tt.data)
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("expected key to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !key.Key.Equal(k.pub) || !bytes.Equal(key.KeyID, tt.keyID) {
+				t.Errorf("unexpected key %+v", key)
+			}
+		})
+	}
+}
+
+func TestVerifyMinisign(t *testing.T) {
+	k := newMinisignKey(t, "12345678")
+	other := newMinisignKey(t, "87654321")
+	sameID := newMinisignKey(t, "12345678")
+	message := []byte("release asset")
+	key, err := gh.ParsePublicKey(k.publicKeyFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     *gh.PublicKey
+		message []byte
+		sig     string
+		err     string // Expected error substring, empty if the signature is valid
+	}{
+		{"pure", key, message, k.sign("Ed", message, "timestamp:1"), ""},
+		{"prehashed", key, message, k.sign("ED", message, "timestamp:1"), ""},
+		{"raw key", &gh.PublicKey{Key: k.pub}, message, k.sign("ED", message, "timestamp:1"), ""},
+		{"CRLF", key, message, strings.ReplaceAll(k.sign("Ed", message, "timestamp:1"), "\n", "\r\n"), ""},
+		{"tampered", key, []byte("release asset!"), k.sign("Ed", message, "timestamp:1"), "invalid signature"},
+		{"tampered prehashed", key, []byte("release asset!"), k.sign("ED", message, "timestamp:1"), "invalid signature"},
+		{"different key ID", key, message, other.sign("ED", message, "timestamp:1"), "different key"},
+		{"wrong key", key, message, sameID.sign("ED", message, "timestamp:1"), "invalid signature"},
+		{"wrong raw key", &gh.PublicKey{Key: other.pub}, message, k.sign("ED", message, "timestamp:1"), "invalid signature"},
+		{
+			"tampered trusted comment", key, message,
+			strings.Replace(k.sign("ED", message, "timestamp:1"), "timestamp:1", "timestamp:2", 1),
+			"invalid trusted comment signature",
+		},
+		{
+			"missing trusted comment", key, message,
+			strings.Replace(k.sign("ED", message, "timestamp:1"), "\ntrusted comment: ", "\ncomment: ", 1),
+			"missing trusted comment",
+		},
+		{
+			"invalid global signature", key, message,
+			strings.Join(strings.Split(k.sign("ED", message, "timestamp:1"), "\n")[:3], "\n") + "\nAAAA\n",
+			"invalid global signature",
+		},
+		{"unsupported algorithm", key, message, k.sign("Ex", message, "timestamp:1"), "unsupported"},
+		{"truncated", key, message, "untrusted comment: signature\nAAAA\n", "invalid minisign signature"},
+		{"invalid signature encoding", key, message, "untrusted comment: signature\n!!!!\ntrusted comment: x\nAAAA\n", "invalid minisign signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.key.Verify(tt.message, []byte(tt.sig))
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if (err == nil) || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestVerifyEd25519(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := []byte("release asset")
+	sig := ed25519.Sign(priv, message)
+
+	tests := []struct {
+		name    string
+		key     ed25519.PublicKey
+		message []byte
+		sig     []byte
+		err     string // Expected error substring, empty if the signature is valid
+	}{
+		{"raw", pub, message, sig, ""},
+		{"base64", pub, message, []byte(base64.StdEncoding.EncodeToString(sig) + "\n"), ""},
+		{"tampered", pub, []byte("release asset!"), sig, "invalid signature"},
+		{"wrong key", otherPub, message, sig, "invalid signature"},
+		{"wrong length", pub, message, []byte(base64.StdEncoding.EncodeToString(sig[:32])), "invalid signature length"},
+		{"invalid encoding", pub, message, []byte("not a signature"), "invalid signature encoding"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&gh.PublicKey{Key: tt.key}).Verify(tt.message, tt.sig)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if (err == nil) || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
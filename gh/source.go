@@ -0,0 +1,293 @@
+package gh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pcm720/psu-go"
+)
+
+// Source is implemented by release providers
+type Source interface {
+	// Returns up to limit repository tags. If limit is 0, returns all tags
+	GetTags(limit int) ([]string, error)
+	// Returns up to limit releases, newest first. If limit is 0, returns all releases
+	GetReleases(limit int) ([]Release, error)
+	// Resolves release by tag
+	GetRelease(tag string) (*Release, error)
+	// Returns the release marked as latest by the provider
+	GetLatestRelease() (*Release, error)
+	// Opens release asset for reading
+	OpenAsset(asset Asset) (io.ReaderAt, int64, error)
+}
+
+// Provider-independent release
+type Release struct {
+	Tag        string    `json:"tag"`
+	Name       string    `json:"name"`
+	Published  time.Time `json:"published"`
+	Updated    time.Time `json:"updated"` // Zero if not provided
+	Draft      bool      `json:"draft"`
+	Prerelease bool      `json:"prerelease"`
+	Body       string    `json:"body"` // Release notes
+	Assets     []Asset   `json:"assets"`
+}
+
+// Provider-independent release asset
+type Asset struct {
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`         // 0 if unknown
+	ContentType string `json:"content_type"` // Empty if unknown
+	Digest      string `json:"digest"`       // Asset hash in the algorithm:hash format (e.g. sha256:abcd). Empty if unknown
+	URL         string `json:"url"`
+}
+
+// Implemented by sources that can download the whole asset with a single request
+type assetDownloader interface {
+	downloadAsset(asset Asset) (io.ReaderAt, int64, error)
+}
+
+// Downloads the whole asset with a single request if the source supports it, opens it with OpenAsset otherwise.
+// Should be used instead of OpenAsset for assets that are read in full, since OpenAsset might read them with many range requests
+func DownloadAsset(s Source, asset Asset) (io.ReaderAt, int64, error) {
+	if d, ok := s.(assetDownloader); ok {
+		return d.downloadAsset(asset)
+	}
+	return s.OpenAsset(asset)
+}
+
+// Returns the release asset matching the name or glob pattern.
+// If pattern is empty, the release must have exactly one asset besides checksum and signature assets
+func (r *Release) FindAsset(pattern string) (Asset, error) {
+	if len(r.Assets) < 1 {
+		return Asset{}, errors.New("no assets")
+	}
+
+	var matched []Asset
+	for _, a := range r.Assets {
+		ok := (pattern == "") && !isAuxiliaryAsset(a.Name)
+		if pattern != "" {
+			var err error
+			if ok, err = path.Match(pattern, a.Name); err != nil {
+				return Asset{}, err
+			}
+		}
+		if ok {
+			matched = append(matched, a)
+		}
+	}
+
+	switch {
+	case len(matched) == 1:
+		return matched[0], nil
+	case pattern == "":
+		return Asset{}, fmt.Errorf("release must have exactly one asset if the asset is not set, available assets: %s", assetNames(r.Assets))
+	case len(matched) == 0:
+		return Asset{}, fmt.Errorf("no assets match '%s', available assets: %s", pattern, assetNames(r.Assets))
+	default:
+		return Asset{}, fmt.Errorf("multiple assets match '%s': %s", pattern, assetNames(matched))
+	}
+}
+
+// Returns true if the asset is a checksum or signature file
+func isAuxiliaryAsset(name string) bool {
+	name = strings.ToLower(name)
+	if isChecksumsFile(name) {
+		return true
+	}
+	for _, ext := range []string{".sha256", ".sig", ".minisig"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func assetNames(assets []Asset) string {
+	names := make([]string, len(assets))
+	for i, a := range assets {
+		names[i] = a.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// Special tag values
+const (
+	TagLatest       = "latest"        // Release marked as latest by the provider
+	TagLatestStable = "latest-stable" // Highest version tag without prerelease identifiers
+)
+
+// Resolves TagLatest, TagLatestStable and version constraints (e.g. '>=1.2.0 <2') into the release tag.
+// Constraints are resolved against the full tag list. Other values are returned as is
+func ResolveTag(s Source, tag string) (string, error) {
+	switch {
+	case tag == TagLatest:
+		release, err := s.GetLatestRelease()
+		if err != nil {
+			return "", err
+		}
+		return release.Tag, nil
+	case tag == TagLatestStable:
+		tag = ">=0.0.0"
+	case !IsConstraint(tag):
+		return tag, nil
+	}
+
+	c, err := ParseConstraint(tag)
+	if err != nil {
+		return "", err
+	}
+	tags, err := s.GetTags(0)
+	if err != nil {
+		return "", err
+	}
+	return MaxVersionTag(tags, c)
+}
+
+// GetFiles options
+type FilesOptions struct {
+	// Asset name or glob pattern.
+	// If empty, the release must have exactly one asset besides checksum and signature assets
+	Asset string
+	// Expected asset SHA-256 hash.
+	// The asset is also verified against the asset digest and SHA256SUMS or <asset name>.sha256 assets if available
+	SHA256 string
+	// Called with the resolved release tag and the selected asset before downloading the asset
+	OnResolved func(tag string, asset Asset)
+	// Called with the asset SHA-256 hash after successful verification
+	OnVerified func(asset Asset, sha256 string)
+	// Public key for detached signature verification.
+	// If set, the release must have a valid <asset name>.minisig or <asset name>.sig signature asset
+	PublicKey *PublicKey
+	// Maps file patterns to PSU file names (e.g. "nhddl-standalone.elf" to "nhddl.elf").
+	// Each pattern must match exactly one of the selected files
+	Rename map[string]string
+}
+
+// Downloads files from the release asset archive (ZIP, tar, tar.gz, tar.xz, tar.zst or 7z).
+// tar.xz, tar.zst and 7z archives are not supported in WebAssembly builds.
+// Tag is resolved with ResolveTag.
+// Files are selected by slash-separated path patterns with path.Match wildcards and '**' elements matching any number of directories.
+// Directory patterns select every file under the directory, patterns starting with '!' exclude files.
+// Returns MatchError if any of the patterns didn't match any file and CollisionError if two files map to the same PSU file.
+// Fails if the asset doesn't match any of the available checksums or the signature is missing or invalid
+func GetFiles(s Source, tag string, targetFiles []string, opts FilesOptions) ([]psu.File, error) {
+	matcher, err := newFileMatcher(targetFiles)
+	if err != nil {
+		return nil, err
+	}
+	renamer, err := newFileRenamer(opts.Rename)
+	if err != nil {
+		return nil, err
+	}
+	reportProgress(s, Progress{Phase: PhaseResolving, Name: tag})
+	tag, err = ResolveTag(s, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	logln("getting release archive for", tag)
+	release, err := s.GetRelease(tag)
+	if err != nil {
+		return nil, err
+	}
+	a, err := release.FindAsset(opts.Asset)
+	if err != nil {
+		return nil, err
+	}
+	if opts.OnResolved != nil {
+		opts.OnResolved(release.Tag, a)
+	}
+	sums, err := collectChecksums(s, release, a, opts.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verification reads the whole asset, so only read it with range requests if there's nothing to verify
+	var (
+		r    io.ReaderAt
+		size int64
+	)
+	if (len(sums) > 0) || (opts.PublicKey != nil) {
+		r, size, err = DownloadAsset(s, a)
+	} else {
+		r, size, err = s.OpenAsset(a)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reportProgress(s, Progress{Phase: PhaseVerifying, Name: a.Name})
+	if len(sums) == 0 {
+		logln("no checksums available for", a.Name, "skipping verification")
+	} else {
+		sum, err := verifyChecksums(r, size, a, sums)
+		if err != nil {
+			return nil, err
+		}
+		logln("verified", a.Name, "SHA-256:", sum)
+		if opts.OnVerified != nil {
+			opts.OnVerified(a, sum)
+		}
+	}
+
+	if opts.PublicKey != nil {
+		if err := verifySignature(s, release, a, r, size, opts.PublicKey); err != nil {
+			return nil, err
+		}
+		logln("verified", a.Name, "signature")
+	}
+
+	logln("opening file", a.Name)
+	out := make([]psu.File, 0, len(targetFiles))
+	err = walkArchive(r, size, a.Name, func(name string, modified time.Time, isDir bool, open func() (io.ReadCloser, error)) error {
+		if isDir || !matcher.match(name) {
+			return nil
+		}
+		psuName, err := renamer.name(name)
+		if err != nil {
+			return err
+		}
+		reportProgress(s, Progress{Phase: PhaseExtracting, Name: name})
+		if psuName != path.Base(name) {
+			logln("adding", name, "as", psuName)
+		} else {
+			logln("adding", name)
+		}
+
+		file, err := open()
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+
+		out = append(out, psu.File{
+			Name:     psuName,
+			Created:  modified,
+			Modified: modified,
+			Data:     data,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := matcher.err(); err != nil {
+		return nil, err
+	}
+	if err := renamer.err(); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no files found")
+	}
+	return out, nil
+}
@@ -0,0 +1,263 @@
+//go:build !js
+
+package gh_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pcm720/nhddl-psu/gh"
+	"github.com/pcm720/nhddl-psu/gh/ghtest"
+)
+
+var dialects = []ghtest.Dialect{ghtest.GitHub, ghtest.Gitea, ghtest.GitLab}
+
+// Starts the server with two releases of pcm720/nhddl, v1.0.0 and v1.1.0.
+// Release assets contain nhddl.elf with the release tag as contents
+func newReleaseServer(t *testing.T, d ghtest.Dialect) *ghtest.Server {
+	t.Helper()
+	s := ghtest.NewDialectServer(d)
+	t.Cleanup(s.Close)
+	for _, tag := range []string{"v1.0.0", "v1.1.0"} {
+		s.AddRelease("pcm720/nhddl", ghtest.Release{
+			Tag: tag,
+			Assets: []ghtest.Asset{{
+				Name: "nhddl-" + tag + ".zip",
+				Data: ghtest.ZIP(map[string][]byte{"nhddl.elf": []byte(tag), "README.md": []byte("readme")}),
+			}},
+		})
+	}
+	return s
+}
+
+func TestDialects(t *testing.T) {
+	for _, d := range dialects {
+		t.Run(d.String(), func(t *testing.T) {
+			src := newReleaseServer(t, d).Source("pcm720/nhddl")
+
+			releases, err := src.GetReleases(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (len(releases) != 2) || (releases[0].Tag != "v1.1.0") || (releases[1].Tag != "v1.0.0") {
+				t.Fatalf("unexpected releases %+v", releases)
+			}
+
+			latest, err := src.GetLatestRelease()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if latest.Tag != "v1.1.0" {
+				t.Errorf("latest release is %s, expected v1.1.0", latest.Tag)
+			}
+
+			release, err := src.GetRelease("v1.0.0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (len(release.Assets) != 1) || (release.Assets[0].Name != "nhddl-v1.0.0.zip") {
+				t.Fatalf("unexpected assets %+v", release.Assets)
+			}
+
+			files, err := gh.GetFiles(src, "v1.0.0", []string{"nhddl.elf"}, gh.FilesOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (len(files) != 1) || (files[0].Name != "nhddl.elf") || !bytes.Equal(files[0].Data, []byte("v1.0.0")) {
+				t.Fatalf("unexpected files %+v", files)
+			}
+		})
+	}
+}
+
+func TestToken(t *testing.T) {
+	for _, d := range dialects {
+		t.Run(d.String(), func(t *testing.T) {
+			s := newReleaseServer(t, d)
+			s.Token = "secret"
+
+			// Returns source with the token that records whether the token has been sent to asset URLs
+			leaked := false
+			newSource := func(token string) gh.Source {
+				f := s.Fetcher("pcm720/nhddl")
+				f.Token = token
+				transport := f.Transport
+				f.Transport = gh.DoerFunc(func(ctx context.Context, req *gh.Request) (*gh.Response, error) {
+					if strings.Contains(req.URL, "/assets/") && ((req.Header.Get("Authorization") != "") || (req.Header.Get("PRIVATE-TOKEN") != "")) {
+						leaked = true
+					}
+					return transport.Do(ctx, req)
+				})
+				if d == ghtest.GitLab {
+					return &gh.GitLab{Fetcher: *f}
+				}
+				return f
+			}
+
+			if _, err := newSource("").GetReleases(0); err == nil {
+				t.Error("expected request without token to fail")
+			}
+			if _, err := newSource("invalid").GetReleases(0); err == nil {
+				t.Error("expected request with invalid token to fail")
+			}
+			if _, err := gh.GetFiles(newSource("secret"), "v1.1.0", []string{"nhddl.elf"}, gh.FilesOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if leaked {
+				t.Error("token has been sent to the asset URL")
+			}
+		})
+	}
+}
+
+func TestVerifiedAssetDownload(t *testing.T) {
+	tests := []struct {
+		dialect    ghtest.Dialect
+		rangeReads bool // Gitea assets have no digest, so there's nothing to verify
+	}{
+		{ghtest.GitHub, false},
+		{ghtest.Gitea, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dialect.String(), func(t *testing.T) {
+			s := newReleaseServer(t, tt.dialect)
+			f := s.Fetcher("pcm720/nhddl")
+			transport := f.Transport
+			assetRequests, rangeRequests := 0, 0
+			f.Transport = gh.DoerFunc(func(ctx context.Context, req *gh.Request) (*gh.Response, error) {
+				if strings.Contains(req.URL, "/assets/") {
+					assetRequests++
+					if req.Header.Get("Range") != "" {
+						rangeRequests++
+					}
+				}
+				return transport.Do(ctx, req)
+			})
+
+			if _, err := gh.GetFiles(f, "v1.1.0", []string{"nhddl.elf"}, gh.FilesOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if tt.rangeReads && (rangeRequests == 0) {
+				t.Error("expected asset to be read with range requests")
+			}
+			if !tt.rangeReads && ((assetRequests != 1) || (rangeRequests != 0)) {
+				t.Errorf("expected verified asset to be downloaded with a single request, got %d requests (%d range requests)", assetRequests, rangeRequests)
+			}
+		})
+	}
+}
+
+func TestFindAsset(t *testing.T) {
+	tests := []struct {
+		name    string
+		assets  []string
+		pattern string
+		want    string // Empty if FindAsset must fail
+	}{
+		{"single", []string{"nhddl.zip"}, "", "nhddl.zip"},
+		{"checksums and signatures", []string{"nhddl.zip", "nhddl.zip.minisig", "nhddl.zip.sig", "nhddl.zip.sha256", "SHA256SUMS", "sha256sums.txt"}, "", "nhddl.zip"},
+		{"multiple", []string{"nhddl.zip", "nhddl.7z"}, "", ""},
+		{"only checksums", []string{"SHA256SUMS"}, "", ""},
+		{"name", []string{"nhddl.zip", "nhddl.7z"}, "nhddl.7z", "nhddl.7z"},
+		{"pattern", []string{"nhddl.zip", "nhddl.7z", "nhddl.zip.minisig"}, "*.zip", "nhddl.zip"},
+		{"explicit checksums", []string{"nhddl.zip", "SHA256SUMS"}, "SHA256SUMS", "SHA256SUMS"},
+		{"multiple matches", []string{"nhddl.zip", "nhddl.zip.minisig"}, "nhddl*", ""},
+		{"no match", []string{"nhddl.zip"}, "*.7z", ""},
+		{"invalid pattern", []string{"nhddl.zip"}, "[", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &gh.Release{}
+			for _, name := range tt.assets {
+				r.Assets = append(r.Assets, gh.Asset{Name: name})
+			}
+			a, err := r.FindAsset(tt.pattern)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("expected error, got %s", a.Name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.Name != tt.want {
+				t.Errorf("got %s, expected %s", a.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignedReleaseDefaultAsset(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range dialects {
+		t.Run(d.String(), func(t *testing.T) {
+			s := ghtest.NewDialectServer(d)
+			t.Cleanup(s.Close)
+			asset := ghtest.Asset{
+				Name: "nhddl.zip",
+				Data: ghtest.ZIP(map[string][]byte{"nhddl.elf": []byte("signed")}),
+			}
+			s.AddRelease("pcm720/nhddl", ghtest.Release{
+				Tag: "v1.0.0",
+				Assets: []ghtest.Asset{
+					asset,
+					{Name: "nhddl.zip.sig", Data: ed25519.Sign(priv, asset.Data)},
+					ghtest.SHA256SUMS(asset),
+				},
+			})
+
+			files, err := gh.GetFiles(s.Source("pcm720/nhddl"), "v1.0.0", []string{"nhddl.elf"}, gh.FilesOptions{PublicKey: &gh.PublicKey{Key: pub}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (len(files) != 1) || !bytes.Equal(files[0].Data, []byte("signed")) {
+				t.Fatalf("unexpected files %+v", files)
+			}
+		})
+	}
+}
+
+func TestSignedReleaseRejected(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asset := ghtest.Asset{Name: "nhddl.zip", Data: ghtest.ZIP(map[string][]byte{"nhddl.elf": []byte("signed")})}
+	tampered := ghtest.Asset{Name: "nhddl.zip", Data: ghtest.ZIP(map[string][]byte{"nhddl.elf": []byte("tampered")})}
+	sig := ghtest.Asset{Name: "nhddl.zip.sig", Data: ed25519.Sign(priv, asset.Data)}
+
+	tests := []struct {
+		name   string
+		assets []ghtest.Asset
+		key    ed25519.PublicKey
+	}{
+		{"tampered asset", []ghtest.Asset{tampered, sig}, pub},
+		{"wrong key", []ghtest.Asset{asset, sig}, otherPub},
+		{"unsigned", []ghtest.Asset{asset}, pub},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := ghtest.NewServer()
+			t.Cleanup(s.Close)
+			s.AddRelease("pcm720/nhddl", ghtest.Release{Tag: "v1.0.0", Assets: tt.assets})
+
+			_, err := gh.GetFiles(s.Source("pcm720/nhddl"), "v1.0.0", []string{"nhddl.elf"}, gh.FilesOptions{Asset: "nhddl.zip", PublicKey: &gh.PublicKey{Key: tt.key}})
+			var sigErr *gh.SignatureError
+			if !errors.As(err, &sigErr) {
+				t.Fatalf("expected SignatureError, got %v", err)
+			}
+		})
+	}
+}
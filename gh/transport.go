@@ -0,0 +1,47 @@
+package gh
+
+import (
+	"context"
+
+	"github.com/pcm720/nhddl-psu/gh/internal/fetch"
+)
+
+// Request and response types used by Doer
+type (
+	Request  = fetch.Request
+	Response = fetch.FetchResponse
+	Header   = fetch.Header // Keys are stored in lower case
+)
+
+// Redirect policies for Request
+const (
+	RedirectFollow = fetch.RedirectFollow
+	RedirectError  = fetch.RedirectError
+	RedirectManual = fetch.RedirectManual
+)
+
+// Doer performs HTTP requests for Fetcher.
+// The response body must be closed by the caller
+type Doer interface {
+	Do(ctx context.Context, req *Request) (*Response, error)
+}
+
+// DoerFunc adapts a function to Doer
+type DoerFunc func(ctx context.Context, req *Request) (*Response, error)
+
+func (f DoerFunc) Do(ctx context.Context, req *Request) (*Response, error) {
+	return f(ctx, req)
+}
+
+// Performs request using the transport if set
+func (g *Fetcher) do(ctx context.Context, req *Request) (*Response, error) {
+	if g.Transport != nil {
+		return g.Transport.Do(ctx, req)
+	}
+	return fetch.Do(ctx, req)
+}
+
+// Performs GET request with given headers, retrying it according to the retry policy
+func (g *Fetcher) doGet(ctx context.Context, url string, header Header) (*Response, error) {
+	return g.doRetry(ctx, &Request{URL: url, Header: header})
+}
@@ -0,0 +1,18 @@
+//go:build !js
+
+package gh
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pcm720/nhddl-psu/gh/internal/fetch"
+)
+
+// Returns Doer that performs requests using the client.
+// Can be used to set a proxy, TLS configuration or timeouts
+func HTTPTransport(client *http.Client) Doer {
+	return DoerFunc(func(ctx context.Context, req *Request) (*Response, error) {
+		return fetch.DoClient(ctx, client, req)
+	})
+}
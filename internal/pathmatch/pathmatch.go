@@ -0,0 +1,31 @@
+// Package pathmatch matches slash-separated paths against patterns with '**' elements.
+// Shared by release archive file patterns and .psuignore rules
+package pathmatch
+
+import "path"
+
+// Returns true if path elements match pattern elements.
+// Pattern elements can contain path.Match wildcards, '**' elements match any number of path elements.
+// If prefix is true, the pattern also matches paths under the matched directory
+func Match(pattern []string, name []string, prefix bool) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Try to match the rest of the pattern at every depth
+			for i := range len(name) + 1 {
+				if Match(pattern[1:], name[i:], prefix) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	// Remaining name elements are files under the matched directory
+	return prefix || (len(name) == 0)
+}
@@ -0,0 +1,39 @@
+package pathmatch_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pcm720/nhddl-psu/internal/pathmatch"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		prefix  bool // Expected result with prefix matching
+		exact   bool // Expected result without prefix matching
+	}{
+		{"nhddl.elf", "nhddl.elf", true, true},
+		{"*.elf", "nhddl.elf", true, true},
+		{"*.elf", "dir/nhddl.elf", false, false},
+		{"dir", "dir/nhddl.elf", true, false},
+		{"dir/*", "dir/sub/nhddl.elf", true, false},
+		{"**/*.elf", "nhddl.elf", true, true},
+		{"**/*.elf", "a/b/nhddl.elf", true, true},
+		{"**/*.elf", "a/b/nhddl.map", false, false},
+		{"a/**", "a/b/c", true, true},
+		{"a/**/c", "a/c", true, true},
+		{"a/**/c", "a/b/c/d", true, false},
+		{"dir/nhddl.elf", "dir", false, false},
+	}
+	for _, tt := range tests {
+		pattern, name := strings.Split(tt.pattern, "/"), strings.Split(tt.name, "/")
+		if got := pathmatch.Match(pattern, name, true); got != tt.prefix {
+			t.Errorf("Match(%s, %s, true) = %t, expected %t", tt.pattern, tt.name, got, tt.prefix)
+		}
+		if got := pathmatch.Match(pattern, name, false); got != tt.exact {
+			t.Errorf("Match(%s, %s, false) = %t, expected %t", tt.pattern, tt.name, got, tt.exact)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+// Package psutime pins PSU directory entry timestamps for reproducible builds.
+//
+// psu.BuildPSU only accepts file timestamps and stamps the directory, '.' and '..' entries with the build time,
+// so the timestamps are patched in the built PSU instead. Patching is safe because PSU entries have a fixed
+// 512-byte layout defined by the format, the copied values are already encoded by psu-go and the format
+// has no checksums that would have to be updated
+package psutime
+
+// PSU entry layout
+const (
+	entrySize      = 512
+	createdOffset  = 0x08 // Creation time, 8 bytes
+	modifiedOffset = 0x18 // Modification time, 8 bytes
+	timeSize       = 8
+	// The directory entry is followed by '.' and '..' entries and file entries
+	dirEntries = 3
+)
+
+// Copies creation and modification time of the first file entry to the directory, '.' and '..' entries.
+// Makes the PSU independent of the build time if file timestamps don't depend on it.
+// Does nothing if the PSU has no files
+func Pin(psu []byte) {
+	if len(psu) < (dirEntries+1)*entrySize {
+		return
+	}
+	file := psu[dirEntries*entrySize:]
+	for i := range dirEntries {
+		entry := psu[i*entrySize:]
+		copy(entry[createdOffset:createdOffset+timeSize], file[createdOffset:createdOffset+timeSize])
+		copy(entry[modifiedOffset:modifiedOffset+timeSize], file[modifiedOffset:modifiedOffset+timeSize])
+	}
+}
@@ -0,0 +1,37 @@
+package psutime_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pcm720/nhddl-psu/internal/psutime"
+)
+
+func TestPin(t *testing.T) {
+	psu := make([]byte, 4*512+1024)
+	for i := range 3 {
+		copy(psu[i*512+0x08:], "dircreat")
+		copy(psu[i*512+0x18:], "dirmodif")
+	}
+	copy(psu[3*512+0x08:], "filecrea")
+	copy(psu[3*512+0x18:], "filemodi")
+	copy(psu[4*512:], "data")
+
+	psutime.Pin(psu)
+	for i := range 4 {
+		entry := psu[i*512:]
+		if !bytes.Equal(entry[0x08:0x10], []byte("filecrea")) || !bytes.Equal(entry[0x18:0x20], []byte("filemodi")) {
+			t.Errorf("entry %d: unexpected timestamps %q, %q", i, entry[0x08:0x10], entry[0x18:0x20])
+		}
+	}
+	if !bytes.Equal(psu[4*512:4*512+4], []byte("data")) {
+		t.Error("file data has been modified")
+	}
+
+	// PSUs without files are left as is
+	empty := bytes.Repeat([]byte{1}, 3*512)
+	psutime.Pin(empty)
+	if !bytes.Equal(empty, bytes.Repeat([]byte{1}, 3*512)) {
+		t.Error("PSU without files has been modified")
+	}
+}